@@ -1,42 +1,185 @@
 package main
 
 import (
-	h "app/internal/headers"
+	"app/internal/client"
+	"app/internal/devcert"
+	"app/internal/metrics"
 	"app/internal/request"
 	"app/internal/response"
 	"app/internal/server"
+	"app/internal/sse"
 	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
-	"log"
-	"net/http"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
+const maxDecodedBodySize = 10 << 20
+
+// Requests under /httpbin are proxied there, set up in main.
+var httpbinProxyHandler server.Handler
+
+// CONNECT requests are tunnelled, set up in main from the -proxy flags.
+var tunnelHandler server.Handler
+
+// Served at /metrics.
+var metricsRegistry = metrics.NewRegistry()
+
+// Where -dev-cert keeps its certificate.
+const (
+	devCertFile = "dev-cert.pem"
+	devKeyFile  = "dev-key.pem"
+)
 
 func main() {
-	server, err := server.Serve(port, handler)
+	certFile := flag.String("tls-cert", "", "serve HTTPS with this PEM certificate file")
+	keyFile := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	devCert := flag.Bool("dev-cert", false, "serve HTTPS with a self-signed certificate for localhost, generated into "+devCertFile+" and "+devKeyFile+" if missing")
+	proxyAllow := flag.String("proxy-allow", "", "comma-separated host:port patterns CONNECT may tunnel to, e.g. \"*.example.com:443\" (none when empty)")
+	proxyAuth := flag.String("proxy-auth", "", "require user:password as Basic Proxy-Authorization for CONNECT")
+	accessLogPath := flag.String("access-log", "-", "append access log lines to this file, reopened on SIGHUP for rotation, or - for stdout")
+	accessLogFormat := flag.String("access-log-format", "combined", "access log format: common, combined or json")
+	var logLevel slog.Level
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "minimum level of the server log: debug, info, warn or error")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	slog.SetDefault(logger)
+
+	logFormat, err := server.ParseLogFormat(*accessLogFormat)
 	if err != nil {
-		log.Fatalf("Error starting server: %v", err)
+		fatal("Invalid -access-log-format", err)
+	}
+	var accessLog io.Writer = os.Stdout
+	var accessLogFile *server.LogFile
+	if *accessLogPath != "-" {
+		accessLogFile, err = server.OpenLogFile(*accessLogPath)
+		if err != nil {
+			fatal("Error opening access log", err)
+		}
+		defer accessLogFile.Close()
+		accessLog = accessLogFile
+	}
+
+	httpbinProxyHandler, err = server.ReverseProxyWithOptions(
+		"https://httpbin.org",
+		server.ProxyOptions{StripPrefix: "/httpbin", ModifyResponse: addContentTrailers},
+	)
+	if err != nil {
+		fatal("Error creating httpbin proxy", err)
+	}
+
+	tunnelOpts := server.TunnelOptions{}
+	if *proxyAllow != "" {
+		tunnelOpts.Allow = strings.Split(*proxyAllow, ",")
+	}
+	if *proxyAuth != "" {
+		tunnelOpts.Authenticate = func(user, password string) bool {
+			return subtle.ConstantTimeCompare([]byte(user+":"+password), []byte(*proxyAuth)) == 1
+		}
+	}
+	tunnelHandler = server.Tunnel(tunnelOpts)
+
+	if *devCert {
+		*certFile, *keyFile = devCertFile, devKeyFile
+		err = ensureDevCert(*certFile, *keyFile)
+		if err != nil {
+			fatal("Error generating development certificate", err)
+		}
+	}
+
+	serverMetrics := metrics.NewServerMetrics(metricsRegistry, metrics.ServerOptions{Route: route})
+	opts := append(serverMetrics.Options(),
+		server.WithStrictParsing(),
+		server.WithLogger(logger),
+		server.AccessLogRejects(accessLog, logFormat),
+	)
+	if *certFile != "" {
+		opts = append(opts, server.WithTLS(server.TLSOptions{
+			Certificates: []server.CertificateFiles{{CertFile: *certFile, KeyFile: *keyFile}},
+		}))
+	}
+
+	server, err := server.Serve(
+		port,
+		server.AccessLog(accessLog, logFormat, serverMetrics.Instrument(server.DecodeBody(maxDecodedBodySize, handler))),
+		opts...,
+	)
+	if err != nil {
+		fatal("Error starting server", err)
 	}
 	defer server.Close()
-	log.Println("Server started on port", port)
+	logger.Info("Server started", "port", port)
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	log.Println("Server gracefully stopped")
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if accessLogFile != nil {
+			err = accessLogFile.Reopen()
+			if err != nil {
+				logger.Error("Error reopening access log", "err", err)
+			}
+		}
+	}
+	logger.Info("Server gracefully stopped")
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// ensureDevCert generates a self-signed certificate for localhost
+// unless one was generated before.
+func ensureDevCert(certFile, keyFile string) error {
+	_, err := os.Stat(certFile)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	slog.Info("Generating self-signed certificate for localhost", "file", certFile)
+	return devcert.WriteFiles(certFile, keyFile, []string{"localhost", "127.0.0.1", "::1"}, 365*24*time.Hour)
 }
 
 func handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "CONNECT" {
+		tunnelHandler(w, req)
+		return
+	}
+
+	acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+	w.Compress(acceptEncoding)
+
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
 		httpbinProxyHandler(w, req)
 		return
 	}
+	if req.RequestLine.RequestTarget == "/metrics" {
+		metrics.Handler(metricsRegistry)(w, req)
+		return
+	}
+	if req.RequestLine.RequestTarget == "/events" {
+		eventsHandler(w, req)
+		return
+	}
 	if req.RequestLine.RequestTarget == "/video" {
 		videoHandler(w, req)
 		return
@@ -53,107 +196,93 @@ func handler(w *response.Writer, req *request.Request) {
 	handle200(w, req)
 }
 
-var httpbinProxyHandler server.Handler = func(w *response.Writer, req *request.Request) {
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/stream") {
-		httpbinStreamHandler(w, req)
-		return
-	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/html") {
-		httpbinHtmlHandler(w, req)
-		return
+// route names the route handler picks for req, for metrics.
+func route(req *request.Request) string {
+	target := req.RequestLine.RequestTarget
+	switch {
+	case req.RequestLine.Method == "CONNECT":
+		return "CONNECT"
+	case strings.HasPrefix(target, "/httpbin"):
+		return "/httpbin"
+	case target == "/metrics", target == "/events", target == "/video", target == "/yourproblem", target == "/myproblem":
+		return target
 	}
+	return "/"
 }
-var httpbinStreamHandler server.Handler = func(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	resp, err := fetchHttpbin(target)
-	if err != nil {
-		handle500(w, req)
-		return
-	}
-	defer resp.Body.Close()
-
-	w.WriteStatusLine(response.StatusOK)
-	headers := response.GetChunkedHeaders()
-	headers.Set("Content-Type", "application/json")
-	w.WriteHeaders(headers)
 
-	_, err = w.WriteChunkedBodyFromReader(resp.Body)
-	if err != nil {
-		log.Fatalf("Error writing chunked body from reader: %v", err)
-		return
-	}
-
-	err = w.WriteTrailers(h.Headers{})
-	if err != nil {
-		log.Fatalf("Error writing trailers: %v", err)
+// addContentTrailers sends the length and SHA-256 of the page
+// /httpbin/html gets as trailers, worked out while it is relayed, along
+// with an ETag made from the same hash. The tag is weak since the page
+// may be sent compressed.
+func addContentTrailers(req *request.Request, resp *client.Response) {
+	if req.RequestLine.RequestTarget != "/httpbin/html" || resp.StatusLine.StatusCode != response.StatusOK {
 		return
 	}
+	resp.Headers.Set("Trailer", "X-Content-SHA256")
+	resp.Headers.Set("Trailer", "X-Content-Length")
+	resp.Headers.Set("Trailer", "ETag")
+	resp.Body = &hashingBody{ReadCloser: resp.Body, resp: resp, hasher: sha256.New()}
 }
-var httpbinHtmlHandler server.Handler = func(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	resp, err := fetchHttpbin(target)
-	if err != nil {
-		handle500(w, req)
-		return
-	}
-	defer resp.Body.Close()
 
-	w.WriteStatusLine(response.StatusOK)
-	headers := response.GetChunkedHeaders()
-	headers.Set("Content-Type", "text/html")
-	headers.Set("Trailer", "X-Content-SHA256")
-	headers.Set("Trailer", "X-Content-Length")
-	w.WriteHeaders(headers)
-
-	hasher := sha256.New()
-	n, err := w.WriteChunkedBodyFromReader(io.TeeReader(resp.Body, hasher))
-	if err != nil {
-		log.Fatalf("Error writing chunked body from reader: %v", err)
-		return
-	}
-
-	trailers := h.Headers{}
-	trailers.Set("X-Content-Length", fmt.Sprintf("%d", n))
-	trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", hasher.Sum(nil)))
+// hashingBody hashes and counts a response body as it is read, and
+// adds both to the response trailers at EOF.
+type hashingBody struct {
+	io.ReadCloser
+	resp   *client.Response
+	hasher hash.Hash
+	n      int64
+}
 
-	err = w.WriteTrailers(trailers)
-	if err != nil {
-		log.Fatalf("Error writing trailers: %v", err)
-		return
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hasher.Write(p[:n])
+	b.n += int64(n)
+	if err == io.EOF {
+		// Only now, since a chunked body replaces the trailers when it
+		// reaches its end.
+		b.resp.Trailers.Replace("X-Content-Length", strconv.FormatInt(b.n, 10))
+		b.resp.Trailers.Replace("X-Content-SHA256", fmt.Sprintf("%x", b.hasher.Sum(nil)))
+		b.resp.Trailers.Replace("ETag", response.ETagFromHash(b.hasher, true))
 	}
+	return n, err
 }
 
 var videoHandler server.Handler = func(w *response.Writer, req *request.Request) {
-	vid, err := os.ReadFile("./assets/vim.mp4")
+	server.ServeFile(w, req, "./assets/vim.mp4")
+}
+
+// eventsHandler streams the time every second, numbering the events so
+// clients that reconnect carry on from where they were.
+var eventsHandler server.Handler = func(w *response.Writer, req *request.Request) {
+	stream, err := sse.Start(w, req)
 	if err != nil {
-		log.Printf("Error reading video file: %v", err)
-		handle500(w, req)
+		req.Log().Error("Error starting event stream", "err", err)
 		return
 	}
+	defer stream.Close()
 
-	w.WriteStatusLine(response.StatusOK)
-	headers := response.GetDefaultHeaders(len(vid))
-	headers.Replace("Content-Type", "video/mp4")
-	w.WriteHeaders(headers)
-	w.WriteBody(vid)
-}
-
-func fetchHttpbin(target string) (*http.Response, error) {
-	resp, err := http.Get("https://httpbin.org/" + target)
-	if err != nil {
-		log.Printf(
-			"Error fetching %s: %v",
-			"https://httpbin.org/"+target,
-			err,
-		)
+	seq, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case now := <-ticker.C:
+			seq++
+			err = stream.Send(sse.Event{ID: strconv.Itoa(seq), Data: now.Format(time.RFC3339)})
+			if err != nil {
+				return
+			}
+		}
 	}
-	return resp, err
 }
 
 var handle200 server.Handler = func(w *response.Writer, _ *request.Request) {
 	w.WriteStatusLine(response.StatusOK)
 	headers := response.GetDefaultHeaders(len(okHtml))
 	headers.Replace("Content-Type", "text/html")
+	headers.Set("ETag", okETag)
 	w.WriteHeaders(headers)
 	w.WriteBody(okHtml)
 }
@@ -181,6 +310,7 @@ var badRequestHtml = []byte(`<html>
     <p>Your request honestly kinda sucked.</p>
   </body>
 </html>`)
+var okETag = response.ETagFromBytes(okHtml, false)
 var internalErrorHtml = []byte(`<html>
   <head>
     <title>500 Internal Server Error</title>
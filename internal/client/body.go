@@ -0,0 +1,225 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidChunk = errors.New("invalid chunk")
+var ErrBodyClosed = errors.New("read on closed response body")
+
+// newBody picks how the response body is delimited, following RFC 9112
+// section 6.3.
+func newBody(reader *bufio.Reader, method string, resp *Response, onDone func(bool)) (io.ReadCloser, error) {
+	code := resp.StatusLine.StatusCode
+	reusable := keepAlive(resp)
+
+	if method == "HEAD" || code < 200 || code == 204 || code == 304 {
+		// 101 hands the connection over to another protocol.
+		b := &body{reusable: reusable && code != 101, release: onDone}
+		b.finish()
+		return b, nil
+	}
+
+	if te, exists := resp.Headers.Get("Transfer-Encoding"); exists {
+		codings := strings.Split(te, ",")
+		last := strings.TrimSpace(codings[len(codings)-1])
+		if !strings.EqualFold(last, "chunked") {
+			return newCloseBody(reader, onDone), nil
+		}
+		return &body{
+			reader:   reader,
+			chunked:  true,
+			trailers: resp,
+			reusable: reusable,
+			release:  onDone,
+		}, nil
+	}
+
+	if value, exists := resp.Headers.Get("Content-Length"); exists {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("Invalid Content-Length in response: %q", value)
+		}
+		b := &body{
+			reader:    reader,
+			remaining: length,
+			reusable:  reusable,
+			release:   onDone,
+		}
+		if length == 0 {
+			b.finish()
+		}
+		return b, nil
+	}
+
+	return newCloseBody(reader, onDone), nil
+}
+
+// keepAlive reports whether the server left the connection open after
+// resp, which has the same rules as for requests.
+func keepAlive(resp *Response) bool {
+	connection, _ := resp.Headers.Get("Connection")
+	if hasToken(connection, "close") {
+		return false
+	}
+	if resp.StatusLine.HttpVersion == "1.0" {
+		return hasToken(connection, "keep-alive")
+	}
+	return true
+}
+
+// newCloseBody returns a body that runs until the server closes the
+// connection, which can't be reused afterwards.
+func newCloseBody(reader *bufio.Reader, onDone func(bool)) *body {
+	return &body{
+		reader:    reader,
+		remaining: -1,
+		release:   onDone,
+	}
+}
+
+// body reads one response body off the connection. remaining counts the
+// bytes left of a Content-Length body (-1 when the body is delimited by
+// the connection closing), or of the current chunk of a chunked body.
+// release is called exactly once, when the body ends or is closed early.
+type body struct {
+	reader    *bufio.Reader
+	remaining int64
+	chunked   bool
+	trailers  *Response
+	reusable  bool
+	release   func(reusable bool)
+
+	done     bool
+	released bool
+	closed   bool
+	err      error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.done {
+		return 0, io.EOF
+	}
+
+	if b.chunked && b.remaining == 0 {
+		err := b.nextChunk()
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+		if b.done {
+			return 0, io.EOF
+		}
+	}
+
+	if b.remaining >= 0 && int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.reader.Read(p)
+	if b.remaining >= 0 {
+		b.remaining -= int64(n)
+	}
+
+	if errors.Is(err, io.EOF) {
+		if b.remaining < 0 {
+			b.finish()
+			return n, io.EOF
+		}
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		b.err = err
+		return n, err
+	}
+
+	if b.remaining == 0 {
+		if !b.chunked {
+			b.finish()
+			return n, io.EOF
+		}
+		err := b.endChunk()
+		if err != nil {
+			b.err = err
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// nextChunk reads a chunk-size line, or the last chunk and the trailer
+// section after it. Chunk extensions are skipped.
+func (b *body) nextChunk() error {
+	line, err := readLine(b.reader)
+	if err != nil {
+		return fmt.Errorf("Error reading chunk size: %w", err)
+	}
+
+	sizeText, _, _ := strings.Cut(line, ";")
+	sizeText = strings.TrimRight(sizeText, " \t")
+	size, err := strconv.ParseInt(sizeText, 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w: size %q", ErrInvalidChunk, sizeText)
+	}
+
+	if size > 0 {
+		b.remaining = size
+		return nil
+	}
+
+	trailers, err := readFields(b.reader)
+	if err != nil {
+		return fmt.Errorf("Error reading trailers: %w", err)
+	}
+	b.trailers.Trailers = trailers
+	b.finish()
+	return nil
+}
+
+// endChunk reads the CRLF after a chunk's data.
+func (b *body) endChunk() error {
+	line, err := readLine(b.reader)
+	if err != nil {
+		return fmt.Errorf("Error reading chunk: %w", err)
+	}
+	if line != "" {
+		return fmt.Errorf("%w: data longer than its size", ErrInvalidChunk)
+	}
+	return nil
+}
+
+func (b *body) finish() {
+	b.done = true
+	b.releaseConn(b.reusable)
+}
+
+func (b *body) releaseConn(reusable bool) {
+	if b.released {
+		return
+	}
+	b.released = true
+	b.release(reusable)
+}
+
+// Close stops reading the body. A body that wasn't read to the end
+// leaves the connection in an unknown place, so it gets closed instead
+// of reused.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.releaseConn(false)
+	return nil
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"app/internal/headers"
+	"app/internal/request"
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxIdlePerHost = 2
+	DefaultIdleTimeout    = 90 * time.Second
+	defaultDialTimeout    = 10 * time.Second
+	readBufferSize        = 4096
+)
+
+// Client sends requests and keeps connections open between them when
+// the server allows it. The zero value is ready to use, and a Client is
+// safe for concurrent use.
+type Client struct {
+	// Dial opens a connection to address. A TCP dial is used when nil.
+	Dial func(address string) (net.Conn, error)
+	// TLSConfig makes connections use TLS when set. ServerName is taken
+	// from the address if empty.
+	TLSConfig *tls.Config
+	// Idle connections kept per address, DefaultMaxIdlePerHost when 0.
+	MaxIdlePerHost int
+	// How long an idle connection is kept, DefaultIdleTimeout when 0.
+	IdleTimeout time.Duration
+	// How long to wait for the response head once the request has been
+	// sent, with no limit when 0. Do fails with ErrResponseHeaderTimeout
+	// when it runs out. The body can take as long as it needs.
+	ResponseHeaderTimeout time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+type persistConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	idleSince time.Time
+}
+
+// Do sends req to the server at address (host:port) and reads the
+// response head. The body is streamed from Response.Body, which must be
+// closed; the connection goes back to the pool once the body has been
+// read to the end. Requests without a Host header get one set to
+// address.
+//
+// A 101 Switching Protocols response is returned with an empty body,
+// but its connection is closed rather than handed over, so Do can't be
+// used to switch protocols.
+//
+// A pooled connection may have been closed by the server while idle, so
+// if it fails before any of the response arrives the request is sent
+// again on a fresh connection. That only happens for idempotent methods,
+// or if none of the request was sent, since otherwise the server may
+// already have acted on it.
+func (c *Client) Do(address string, req *request.Request) (*Response, error) {
+	if _, exists := req.Headers.Get("Host"); !exists {
+		withHost := *req
+		withHost.Headers = headers.Headers{}
+		withHost.Headers.Merge(req.Headers)
+		withHost.Headers.Set("Host", address)
+		req = &withHost
+	}
+
+	pc := c.getIdle(address)
+	if pc != nil {
+		resp, err := c.roundTrip(address, pc, req)
+		if err == nil {
+			return resp, nil
+		}
+		if err != errStaleConn {
+			return nil, err
+		}
+	}
+
+	pc, err := c.dial(address)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(address, pc, req)
+	if err == errStaleConn {
+		return nil, fmt.Errorf("Error reading response from %s: connection closed", address)
+	}
+	return resp, err
+}
+
+var errStaleConn = errors.New("connection closed before response")
+
+var ErrResponseHeaderTimeout = errors.New("timeout awaiting response headers")
+
+func (c *Client) roundTrip(address string, pc *persistConn, req *request.Request) (*Response, error) {
+	cw := &countingWriter{w: pc.conn}
+	err := WriteRequest(cw, req)
+	if err != nil {
+		pc.conn.Close()
+		return nil, staleConnError(address, req, cw.n > 0)
+	}
+
+	if c.ResponseHeaderTimeout > 0 {
+		pc.conn.SetReadDeadline(time.Now().Add(c.ResponseHeaderTimeout))
+	}
+
+	// Nothing has come back yet if this fails, so the request may be
+	// retried, unless the server just didn't answer in time.
+	_, err = pc.reader.Peek(1)
+	if err != nil {
+		pc.conn.Close()
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w from %s", ErrResponseHeaderTimeout, address)
+		}
+		return nil, staleConnError(address, req, true)
+	}
+
+	resp, err := ReadResponse(pc.reader, req.RequestLine.Method, func(reusable bool) {
+		if reusable {
+			c.putIdle(address, pc)
+		} else {
+			pc.conn.Close()
+		}
+	})
+	if err != nil {
+		pc.conn.Close()
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w from %s", ErrResponseHeaderTimeout, address)
+		}
+		return nil, fmt.Errorf("Error reading response from %s: %w", address, err)
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		pc.conn.SetReadDeadline(time.Time{})
+	}
+	return resp, nil
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// staleConnError returns errStaleConn, which has Do send the request
+// again, when that can't make it run twice: none of it was sent, or its
+// method is idempotent.
+func staleConnError(address string, req *request.Request, sent bool) error {
+	method, known := request.LookupMethod(req.RequestLine.Method)
+	if !sent || (known && method.Idempotent) {
+		return errStaleConn
+	}
+	return fmt.Errorf("Error reading response from %s: connection closed", address)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (c *Client) dial(address string) (*persistConn, error) {
+	var conn net.Conn
+	var err error
+	if c.Dial != nil {
+		conn, err = c.Dial(address)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, defaultDialTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to %s: %w", address, err)
+	}
+
+	if c.TLSConfig != nil {
+		config := c.TLSConfig.Clone()
+		if config.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				host = address
+			}
+			config.ServerName = host
+		}
+		tlsConn := tls.Client(conn, config)
+		err := tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error in TLS handshake with %s: %w", address, err)
+		}
+		conn = tlsConn
+	}
+
+	return &persistConn{conn: conn, reader: bufio.NewReaderSize(conn, readBufferSize)}, nil
+}
+
+// getIdle takes the most recently used idle connection for address,
+// closing any that have been idle for too long.
+func (c *Client) getIdle(address string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conns := c.idle[address]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(pc.idleSince) < c.idleTimeout() {
+			c.idle[address] = conns
+			return pc
+		}
+		pc.conn.Close()
+	}
+	delete(c.idle, address)
+	return nil
+}
+
+func (c *Client) putIdle(address string, pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	maxIdle := c.MaxIdlePerHost
+	if maxIdle == 0 {
+		maxIdle = DefaultMaxIdlePerHost
+	}
+	if len(c.idle[address]) >= maxIdle {
+		pc.conn.Close()
+		return
+	}
+
+	if c.idle == nil {
+		c.idle = map[string][]*persistConn{}
+	}
+	pc.idleSince = time.Now()
+	c.idle[address] = append(c.idle[address], pc)
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return c.IdleTimeout
+}
+
+// CloseIdleConnections closes every pooled connection.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, conns := range c.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+	}
+	c.idle = nil
+}
@@ -0,0 +1,352 @@
+package client
+
+import (
+	"app/internal/headers"
+	"app/internal/request"
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawServer answers each request it reads with the next of responses,
+// closing the connection after any response ending in a close marker.
+type rawServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    int
+	requests []*request.Request
+}
+
+const closeAfter = "<close>"
+
+func startRawServer(t *testing.T, responses ...string) *rawServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &rawServer{listener: listener}
+	next := make(chan string, len(responses))
+	for _, resp := range responses {
+		next <- resp
+	}
+	close(next)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn, next)
+		}
+	}()
+	return s
+}
+
+func (s *rawServer) serve(conn net.Conn, next chan string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := request.RequestFromReader(reader)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		resp, ok := <-next
+		if !ok {
+			return
+		}
+		resp, closeConn := strings.CutSuffix(resp, closeAfter)
+		conn.Write([]byte(resp))
+		if closeConn {
+			return
+		}
+	}
+}
+
+func (s *rawServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *rawServer) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func newRequest(method, target string, body []byte) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.Headers{},
+		Body:        body,
+	}
+}
+
+func TestResponseParse(t *testing.T) {
+	// Test: Content-Length body
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello",
+	)), "GET", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", resp.StatusLine.HttpVersion)
+	assert.Equal(t, 200, int(resp.StatusLine.StatusCode))
+	assert.Equal(t, "OK", resp.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", resp.Headers["content-type"])
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Chunked body with extensions and trailers
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"+
+			"5;name=value\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+	)), "GET", nil)
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", resp.Trailers["x-checksum"])
+
+	// Test: Close-delimited body
+	reusable := true
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.0 200 OK\r\n\r\nuntil the end",
+	)), "GET", func(r bool) { reusable = r })
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+	assert.False(t, reusable)
+
+	// Test: Responses to HEAD have no body
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+	)), "HEAD", nil)
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Interim responses are skipped
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+	)), "POST", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 204, int(resp.StatusLine.StatusCode))
+	assert.NotContains(t, resp.Headers, "link")
+
+	// Test: Missing reason phrase
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 404\r\nContent-Length: 0\r\n\r\n",
+	)), "GET", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 404, int(resp.StatusLine.StatusCode))
+	assert.Equal(t, "", resp.StatusLine.ReasonPhrase)
+
+	// Test: Truncated bodies are errors
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello",
+	)), "GET", nil)
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Bad chunk sizes
+	resp, err = ReadResponse(bufio.NewReader(strings.NewReader(
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+	)), "GET", nil)
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	assert.ErrorIs(t, err, ErrInvalidChunk)
+
+	// Test: Invalid status-lines
+	for _, line := range []string{"HTTP/1.1\r\n", "HTTP/1.1 2000 OK\r\n", "ICY 200 OK\r\n", "HTTP/1.1 abc OK\r\n"} {
+		_, err = ReadResponse(bufio.NewReader(strings.NewReader(line+"\r\n")), "GET", nil)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestWriteRequest(t *testing.T) {
+	// Test: Content-Length is added for bodies
+	var buf strings.Builder
+	req := newRequest("POST", "/submit", []byte("hello"))
+	req.Headers.Set("Host", "example.com")
+	err := WriteRequest(&buf, req)
+	require.NoError(t, err)
+	parsed, err := request.RequestFromReader(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "POST", parsed.RequestLine.Method)
+	assert.Equal(t, "/submit", parsed.RequestLine.RequestTarget)
+	assert.Equal(t, "example.com", parsed.Headers["host"])
+	assert.Equal(t, "5", parsed.Headers["content-length"])
+	assert.Equal(t, "hello", string(parsed.Body))
+
+	// Test: No body, no Content-Length
+	buf.Reset()
+	err = WriteRequest(&buf, newRequest("GET", "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", buf.String())
+}
+
+func TestClientConnectionReuse(t *testing.T) {
+	s := startRawServer(t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 5\r\n\r\nthird"+closeAfter,
+		"HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nfourth",
+	)
+	c := &Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	get := func(target string) string {
+		resp, err := c.Do(s.addr(), newRequest("GET", target, nil))
+		require.NoError(t, err)
+		body, err := resp.ReadBody()
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	// Test: Keep-alive responses share a connection
+	assert.Equal(t, "first", get("/1"))
+	assert.Equal(t, "second", get("/2"))
+	assert.Equal(t, 1, s.connCount())
+
+	// Test: Connection: close means a new connection next time
+	assert.Equal(t, "third", get("/3"))
+	assert.Equal(t, "fourth", get("/4"))
+	assert.Equal(t, 2, s.connCount())
+
+	// Test: Host is filled in from the address
+	require.Len(t, s.requests, 4)
+	assert.Equal(t, s.addr(), s.requests[0].Headers["host"])
+}
+
+func TestClientRetriesStaleConnection(t *testing.T) {
+	// The server closes the connection after the first response without
+	// saying so, like an idle timeout would.
+	s := startRawServer(t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst"+closeAfter,
+		"HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nsecond",
+	)
+	c := &Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	resp, err := c.Do(s.addr(), newRequest("GET", "/1", nil))
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	require.NoError(t, err)
+
+	// Test: The request goes out again on a fresh connection
+	resp, err = c.Do(s.addr(), newRequest("GET", "/2", nil))
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(body))
+	assert.Equal(t, 2, s.connCount())
+}
+
+func TestClientDoesNotRetryNonIdempotent(t *testing.T) {
+	// The server reads the second request and closes the connection
+	// without answering it.
+	s := startRawServer(t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst",
+		closeAfter,
+		"HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nsecond",
+	)
+	c := &Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	resp, err := c.Do(s.addr(), newRequest("GET", "/1", nil))
+	require.NoError(t, err)
+	_, err = resp.ReadBody()
+	require.NoError(t, err)
+
+	// Test: A POST that may have reached the server isn't sent again
+	_, err = c.Do(s.addr(), newRequest("POST", "/2", []byte("once")))
+	require.Error(t, err)
+	assert.Equal(t, 1, s.connCount())
+	s.mu.Lock()
+	assert.Len(t, s.requests, 2)
+	s.mu.Unlock()
+}
+
+func TestClientUnreadBody(t *testing.T) {
+	s := startRawServer(t,
+		"HTTP/1.1 200 OK\r\nContent-Length: 11\r\n\r\nhello world",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+	)
+	c := &Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Closing a body early doesn't put the connection back
+	resp, err := c.Do(s.addr(), newRequest("GET", "/", nil))
+	require.NoError(t, err)
+	part := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, part)
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, err = resp.Body.Read(part)
+	assert.ErrorIs(t, err, ErrBodyClosed)
+
+	resp, err = c.Do(s.addr(), newRequest("GET", "/", nil))
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, 2, s.connCount())
+}
+
+func TestClientResponseHeaderTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := request.RequestFromReader(bufio.NewReader(conn))
+				if err != nil || req.RequestLine.RequestTarget == "/stall" {
+					io.Copy(io.Discard, conn)
+					return
+				}
+				// A prompt head with a slow body.
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nsl"))
+				time.Sleep(100 * time.Millisecond)
+				conn.Write([]byte("ow"))
+			}()
+		}
+	}()
+	c := &Client{ResponseHeaderTimeout: 50 * time.Millisecond}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: A server that doesn't answer makes Do fail instead of hang
+	start := time.Now()
+	_, err = c.Do(listener.Addr().String(), newRequest("GET", "/stall", nil))
+	assert.ErrorIs(t, err, ErrResponseHeaderTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Test: The body isn't held to the timeout
+	resp, err := c.Do(listener.Addr().String(), newRequest("GET", "/slow", nil))
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "slow", string(body))
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"app/internal/headers"
+	"app/internal/response"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Longest status-line or field line ReadResponse accepts.
+const maxLineLength = 64 << 10
+
+var ErrLineTooLong = errors.New("response line too long")
+
+type Response struct {
+	StatusLine StatusLine
+	// Headers combines repeated fields, except Set-Cookie, whose lines
+	// are kept apart, see headers.Headers.Values.
+	Headers headers.Headers
+	// Body streams the response body and must be closed. The
+	// connection only goes back to the pool once the body has been
+	// read to the end.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body. It is only
+	// filled in once Body has been read to EOF.
+	Trailers headers.Headers
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   response.StatusCode
+	ReasonPhrase string
+}
+
+// ReadBody reads the whole body and closes it.
+func (r *Response) ReadBody() ([]byte, error) {
+	defer r.Body.Close()
+	return io.ReadAll(r.Body)
+}
+
+// ReadResponse reads a response to a request with the given method
+// from reader. Interim 1xx responses are skipped, except for 101
+// Switching Protocols, which is returned with no body. onDone is called
+// once, when the body has been read to the end or closed, with whether
+// the connection can be reused for another request. It may be nil. For
+// 101 that is right away and with false, since the connection now
+// speaks another protocol: a caller that owns the connection can go on
+// using it with whatever is left in reader, while Client.Do closes it.
+func ReadResponse(reader *bufio.Reader, method string, onDone func(reusable bool)) (*Response, error) {
+	resp := &Response{Trailers: headers.Headers{}}
+
+	for {
+		statusLine, err := readStatusLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		resp.StatusLine = statusLine
+
+		resp.Headers, err = readFields(reader)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading response headers: %w", err)
+		}
+
+		code := statusLine.StatusCode
+		if code < 100 || code > 199 || code == 101 {
+			break
+		}
+	}
+
+	if onDone == nil {
+		onDone = func(bool) {}
+	}
+	body, err := newBody(reader, method, resp, onDone)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = body
+
+	return resp, nil
+}
+
+// readStatusLine parses status-line = HTTP-version SP status-code SP
+// [ reason-phrase ] (RFC 9112 section 4). Some servers leave out the
+// last SP along with the reason, so that is accepted too.
+func readStatusLine(reader *bufio.Reader) (StatusLine, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return StatusLine{}, err
+	}
+
+	version, rest, found := strings.Cut(line, " ")
+	if !found {
+		return StatusLine{}, fmt.Errorf("Invalid status-line: %q", line)
+	}
+	versionNumber, isHTTP := strings.CutPrefix(version, "HTTP/")
+	if !isHTTP || len(versionNumber) != 3 || versionNumber[1] != '.' {
+		return StatusLine{}, fmt.Errorf("Invalid HTTP version in status-line: %q", line)
+	}
+
+	codeText, reason, _ := strings.Cut(rest, " ")
+	if len(codeText) != 3 {
+		return StatusLine{}, fmt.Errorf("Invalid status code in status-line: %q", line)
+	}
+	code, err := strconv.Atoi(codeText)
+	if err != nil || code < 100 {
+		return StatusLine{}, fmt.Errorf("Invalid status code in status-line: %q", line)
+	}
+
+	return StatusLine{
+		HttpVersion:  versionNumber,
+		StatusCode:   response.StatusCode(code),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+// readFields reads field lines up to and including the empty line that
+// ends them, for both headers and trailers.
+func readFields(reader *bufio.Reader) (headers.Headers, error) {
+	fields := headers.Headers{}
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		_, done, err := fields.Parse([]byte(line + "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return fields, nil
+		}
+	}
+}
+
+// readLine reads a CRLF (or bare LF) terminated line without its line
+// ending.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		line = append(line, fragment...)
+		if len(line) > maxLineLength {
+			return "", ErrLineTooLong
+		}
+		if err == nil {
+			break
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	return string(line), nil
+}
+
+// hasToken reports whether a comma separated field value contains
+// token, ignoring case.
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
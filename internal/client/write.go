@@ -0,0 +1,37 @@
+package client
+
+import (
+	"app/internal/request"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteRequest serializes req to w. The request-line version defaults
+// to 1.1, and a Content-Length is added for a body sent without any
+// framing fields. A body that hasn't been read yet must be read with
+// req.ReadBody first.
+func WriteRequest(w io.Writer, req *request.Request) error {
+	version := req.RequestLine.HttpVersion
+	if version == "" {
+		version = "1.1"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/%s\r\n", req.RequestLine.Method, req.RequestLine.RequestTarget, version)
+	for _, field := range req.Headers.Fields() {
+		buf.WriteString(field.Name + ": " + field.Value + "\r\n")
+	}
+
+	_, hasLength := req.Headers.Get("Content-Length")
+	_, hasEncoding := req.Headers.Get("Transfer-Encoding")
+	if req.Body != nil && !hasLength && !hasEncoding {
+		buf.WriteString("content-length: " + strconv.Itoa(len(req.Body)) + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(req.Body)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
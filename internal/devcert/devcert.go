@@ -0,0 +1,89 @@
+// Package devcert generates self-signed certificates for trying out TLS
+// locally. Nothing trusts them unless it is told to.
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// Generate creates a self-signed ECDSA P-256 certificate for hosts,
+// which are DNS names or IP addresses, valid from now for validFor. It
+// returns the certificate and its private key PEM-encoded.
+//
+// The certificate can be used by servers and clients alike and is its
+// own CA, so it can be added to a pool of roots as it is.
+func Generate(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("Certificate needs at least one host")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error generating key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"httpfromtcp development"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error encoding key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// WriteFiles generates a certificate like Generate and writes it to
+// certFile and keyFile. Only the owner can read the key.
+func WriteFiles(certFile, keyFile string, hosts []string, validFor time.Duration) error {
+	certPEM, keyPEM, err := Generate(hosts, validFor)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(keyFile, keyPEM, 0o600)
+	if err != nil {
+		return fmt.Errorf("Error writing key: %w", err)
+	}
+	err = os.WriteFile(certFile, certPEM, 0o644)
+	if err != nil {
+		return fmt.Errorf("Error writing certificate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package devcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	// Test: The certificate covers its hosts and verifies against itself
+	certPEM, keyPEM, err := Generate([]string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	cert := pair.Leaf
+	assert.Equal(t, []string{"localhost"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	assert.True(t, cert.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}})
+		assert.NoError(t, err)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots})
+	assert.Error(t, err)
+
+	// Test: Written files load as a key pair
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, WriteFiles(certFile, keyFile, []string{"localhost"}, time.Hour))
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+
+	// Test: Hosts are required
+	_, _, err = Generate(nil, time.Hour)
+	assert.Error(t, err)
+}
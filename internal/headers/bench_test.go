@@ -0,0 +1,23 @@
+package headers
+
+import "testing"
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	lines := [][]byte{
+		[]byte("Host: localhost:42069\r\n"),
+		[]byte("User-Agent: curl/7.81.0\r\n"),
+		[]byte("Accept: */*\r\n"),
+		[]byte("X-Custom-Header: something\r\n"),
+		[]byte("\r\n"),
+	}
+	for b.Loop() {
+		h := Headers{}
+		for _, line := range lines {
+			_, _, err := h.Parse(line)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
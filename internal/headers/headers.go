@@ -2,52 +2,150 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
 )
 
+var ErrObsoleteLineFolding = errors.New("obsolete line folding is not allowed")
+var ErrInvalidFieldValue = errors.New("field value contains invalid characters")
+var ErrDuplicateField = errors.New("field must not be repeated")
+
+// Fields that may only appear once, because a second value would make
+// the message ambiguous. Checked by ParseStrict.
+var singletonFields = []string{"content-length", "host"}
+
+// Fields whose repeated lines can't be combined into one comma-separated
+// value, because their values contain commas of their own (RFC 9110
+// section 5.3). Set keeps their values apart with a newline instead,
+// and Fields gives them back as separate lines.
+var separateFields = []string{"set-cookie"}
+
+const fieldSeparator = "\n"
+
 type Headers map[string]string
 
+// Field is a single parsed field line.
+type Field struct {
+	Name  string
+	Value string
+}
+
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	_, n, done, err = h.ParseField(data, false)
+	return n, done, err
+}
+
+// ParseStrict is Parse with the stricter rules needed to keep every
+// party that reads the message agreeing on it (RFC 9112 section 5):
+// no whitespace before the field name, which is how obsolete line
+// folding starts, no control characters in values, and no repeats of
+// fields that must appear only once.
+func (h Headers) ParseStrict(data []byte) (n int, done bool, err error) {
+	_, n, done, err = h.ParseField(data, true)
+	return n, done, err
+}
+
+// ParseField works like Parse, or ParseStrict when strict is set, and
+// also returns the field it just added, for callers that want to see
+// each field line as it comes in.
+func (h Headers) ParseField(data []byte, strict bool) (field Field, n int, done bool, err error) {
 	crlf := bytes.Index(data, []byte{'\r', '\n'})
 	if crlf == -1 {
 		// Not enough data to parse
-		return 0, false, nil
+		return Field{}, 0, false, nil
 	}
 	if crlf == 0 {
 		// End of field lines
-		return 2, true, nil
+		return Field{}, 2, true, nil
 	}
 
-	nameColon := bytes.Index(data, []byte{':'})
+	line := data[:crlf]
+	if strict && (line[0] == ' ' || line[0] == '\t') {
+		return Field{}, 0, false, fmt.Errorf("%w: %q", ErrObsoleteLineFolding, line)
+	}
 
-	if data[nameColon-1] == ' ' || data[nameColon-1] == '\t' {
-		return 0, false, fmt.Errorf("No whitespace is allowed between the field name and colon.")
+	nameColon := bytes.IndexByte(line, ':')
+	if nameColon == -1 {
+		return Field{}, 0, false, fmt.Errorf("Field line has no colon: %q", line)
 	}
 
-	fieldName := bytes.TrimSpace(data[0:nameColon])
-	fieldValue := bytes.TrimSpace(data[nameColon+1 : crlf])
+	if nameColon > 0 && (line[nameColon-1] == ' ' || line[nameColon-1] == '\t') {
+		return Field{}, 0, false, fmt.Errorf("No whitespace is allowed between the field name and colon.")
+	}
+
+	fieldName := bytes.TrimSpace(line[0:nameColon])
+	fieldValue := bytes.TrimSpace(line[nameColon+1:])
+	if strict {
+		// Only SP and HTAB are optional whitespace, anything else
+		// left at the edges must be caught by the value check.
+		fieldValue = bytes.Trim(line[nameColon+1:], " \t")
+	}
 
 	if !isValidFieldName(fieldName) {
-		return 0, false, fmt.Errorf("Field name contains invalid characters: %s", fieldName)
+		return Field{}, 0, false, fmt.Errorf("Field name contains invalid characters: %s", fieldName)
 	}
 
-	h.Set(string(fieldName), string(fieldValue))
+	name, key := internName(fieldName)
+
+	if strict {
+		if !isValidFieldValue(fieldValue) {
+			return Field{}, 0, false, fmt.Errorf("%w: %s: %q", ErrInvalidFieldValue, fieldName, fieldValue)
+		}
+		_, exists := h[key]
+		if exists && slices.Contains(singletonFields, key) {
+			return Field{}, 0, false, fmt.Errorf("%w: %s", ErrDuplicateField, fieldName)
+		}
+	}
 
-	return crlf + 2, false, nil
+	field = Field{Name: name, Value: string(fieldValue)}
+	h.Set(key, field.Value)
+
+	return field, crlf + 2, false, nil
 }
 
 func (h Headers) Set(key, value string) {
 	key = strings.ToLower(key)
 	currentVal, alreadyExists := h[key]
 	if alreadyExists {
-		h[key] = currentVal + ", " + value
+		separator := ", "
+		if slices.Contains(separateFields, key) {
+			separator = fieldSeparator
+		}
+		h[key] = currentVal + separator + value
 	} else {
 		h[key] = value
 	}
 }
 
+// Values returns the value of each field line with the name key. That
+// is a single combined value, except for fields such as Set-Cookie
+// whose lines are kept apart.
+func (h Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	value, ok := h[key]
+	if !ok {
+		return nil
+	}
+	if slices.Contains(separateFields, key) {
+		return strings.Split(value, fieldSeparator)
+	}
+	return []string{value}
+}
+
+// Fields returns the field lines to send for h, one per value of the
+// fields Values splits up.
+func (h Headers) Fields() []Field {
+	fields := make([]Field, 0, len(h))
+	for name := range h {
+		for _, value := range h.Values(name) {
+			fields = append(fields, Field{Name: name, Value: value})
+		}
+	}
+	return fields
+}
+
 func (h Headers) Get(key string) (string, bool) {
 	key = strings.ToLower(key)
 	value, ok := h[key]
@@ -95,3 +193,14 @@ func isValidFieldName(name []byte) bool {
 
 	return true
 }
+
+// isValidFieldValue rejects control characters other than HTAB, which
+// includes the bare CR, LF and NUL that RFC 9110 section 5.5 forbids.
+func isValidFieldValue(value []byte) bool {
+	for _, char := range value {
+		if (char < ' ' && char != '\t') || char == 0x7f {
+			return false
+		}
+	}
+	return true
+}
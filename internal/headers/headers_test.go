@@ -92,4 +92,83 @@ func TestFieldLineParse(t *testing.T) {
 	assert.Equal(t, 29, n)
 	assert.Equal(t, 1, len(headers))
 	assert.False(t, done)
+
+	// Test: Field line without a colon
+	headers = Headers{}
+	data = []byte("Host localhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	// Test: Field line starting with a colon
+	headers = Headers{}
+	data = []byte(": localhost\r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+}
+
+func TestStrictFieldLineParse(t *testing.T) {
+	// Test: Valid header
+	headers := Headers{}
+	data := []byte("Host: localhost:42069\r\n\r\n")
+	n, done, err := headers.ParseStrict(data)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, 23, n)
+	assert.False(t, done)
+
+	// Test: Leading whitespace is obsolete line folding
+	headers = Headers{}
+	data = []byte("   Host: localhost:42069\r\n\r\n")
+	n, _, err = headers.ParseStrict(data)
+	require.ErrorIs(t, err, ErrObsoleteLineFolding)
+	assert.Equal(t, 0, n)
+
+	// Test: Control characters in value
+	headers = Headers{}
+	data = []byte("X-Foo: a\x00b\r\n\r\n")
+	_, _, err = headers.ParseStrict(data)
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+
+	// Test: Repeated singleton field
+	headers = Headers{}
+	headers.Set("Content-Length", "3")
+	data = []byte("content-length: 3\r\n\r\n")
+	_, _, err = headers.ParseStrict(data)
+	require.ErrorIs(t, err, ErrDuplicateField)
+
+	// Test: Repeated list field is fine
+	headers = Headers{}
+	headers.Set("Accept", "text/html")
+	data = []byte("Accept: */*\r\n\r\n")
+	_, _, err = headers.ParseStrict(data)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html, */*", headers["accept"])
+}
+
+func TestSeparateFields(t *testing.T) {
+	// Test: Repeated fields are combined with commas
+	headers := Headers{}
+	headers.Set("Accept", "text/html")
+	headers.Set("Accept", "text/plain")
+	assert.Equal(t, "text/html, text/plain", headers["accept"])
+	assert.Equal(t, []string{"text/html, text/plain"}, headers.Values("Accept"))
+
+	// Test: Set-Cookie lines are kept apart, commas and all
+	data := []byte("Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n")
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, headers.Values("set-cookie"))
+	assert.Nil(t, headers.Values("X-Missing"))
+
+	// Test: Each value is a field line of its own
+	fields := headers.Fields()
+	assert.Len(t, fields, 3)
+	assert.Contains(t, fields, Field{Name: "set-cookie", Value: "b=2"})
+	assert.Contains(t, fields, Field{Name: "accept", Value: "text/html, text/plain"})
 }
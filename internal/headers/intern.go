@@ -0,0 +1,75 @@
+package headers
+
+import "strings"
+
+// internedName is a field name as spelled on the wire and its
+// lowercase map key.
+type internedName struct {
+	name  string
+	lower string
+}
+
+// Field names seen in almost every request. Parsing looks them up by
+// the raw bytes, which doesn't allocate, and reuses these strings
+// instead of making new ones for every field line.
+var commonFieldNames = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Authorization",
+	"Cache-Control",
+	"Connection",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Cookie",
+	"Expect",
+	"Forwarded",
+	"Host",
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Range",
+	"If-Unmodified-Since",
+	"Last-Event-ID",
+	"Origin",
+	"Pragma",
+	"Range",
+	"Referer",
+	"Sec-Fetch-Dest",
+	"Sec-Fetch-Mode",
+	"Sec-Fetch-Site",
+	"Sec-WebSocket-Key",
+	"Sec-WebSocket-Version",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Upgrade-Insecure-Requests",
+	"User-Agent",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Request-ID",
+}
+
+// Keyed by both the canonical and the lowercase spelling.
+var internedNames = map[string]internedName{}
+
+func init() {
+	for _, name := range commonFieldNames {
+		lower := strings.ToLower(name)
+		internedNames[name] = internedName{name: name, lower: lower}
+		internedNames[lower] = internedName{name: lower, lower: lower}
+	}
+}
+
+// internName returns the field name as a string along with its map key,
+// without allocating for common names.
+func internName(name []byte) (string, string) {
+	if interned, ok := internedNames[string(name)]; ok {
+		return interned.name, interned.lower
+	}
+	nameText := string(name)
+	return nameText, strings.ToLower(nameText)
+}
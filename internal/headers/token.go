@@ -0,0 +1,12 @@
+package headers
+
+// IsToken reports whether s is a valid RFC 9110 token, the same
+// grammar used for field names, methods and chunk extension names.
+func IsToken(s string) bool {
+	return isValidFieldName([]byte(s))
+}
+
+// IsTokenBytes is IsToken for callers that haven't made a string yet.
+func IsTokenBytes(b []byte) bool {
+	return isValidFieldName(b)
+}
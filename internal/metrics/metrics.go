@@ -0,0 +1,325 @@
+// Package metrics keeps counters, gauges and histograms and exposes
+// them in the Prometheus text exposition format (version 0.0.4), with
+// no dependencies outside the standard library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Content-Type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the histogram bucket upper bounds used when none
+// are given, suited to request latencies in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Registry holds metrics and writes them out together. The New methods
+// panic on invalid or duplicate names, which are mistakes in the
+// program rather than conditions to handle.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*metric{}}
+}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// metric is a metric with all its series, one for each combination of
+// label values seen.
+type metric struct {
+	name    string
+	help    string
+	kind    metricType
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	// The counter or gauge value, or the histogram sum.
+	value float64
+	// Histogram observations per bucket, not cumulative, and in total.
+	bucketCounts []uint64
+	count        uint64
+}
+
+func (r *Registry) register(name, help string, kind metricType, buckets []float64, labels []string) *metric {
+	if !metricNameRe.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !labelNameRe.MatchString(label) || strings.HasPrefix(label, "__") || (kind == histogramType && label == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", label, name))
+		}
+	}
+
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	if len(labels) == 0 {
+		// Shown as 0 before anything happens, rather than missing.
+		m.get(nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[name]; exists {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// get returns the series for labelValues, creating it if needed. It
+// must be called with m.mu held, except from register.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got values %q", m.name, m.labels, labelValues))
+	}
+	var key strings.Builder
+	for _, value := range labelValues {
+		// Quoted, so that no two lists of values give the same key.
+		key.WriteString(strconv.Quote(value))
+	}
+	s, ok := m.series[key.String()]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if m.kind == histogramType {
+			s.bucketCounts = make([]uint64, len(m.buckets))
+		}
+		m.series[key.String()] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct{ m *metric }
+
+// NewCounter registers a counter, which has a value for every
+// combination of values of the given labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, counterType, nil, labels)}
+}
+
+// Inc adds 1 to the counter for labelValues, given in the order the
+// labels were registered in.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for
+// labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s decreased", c.m.name))
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(labelValues).value += v
+}
+
+// Gauge is a value that goes up and down, such as a number of open
+// connections.
+type Gauge struct{ m *metric }
+
+// NewGauge registers a gauge, see NewCounter.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, gaugeType, nil, labels)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value += v
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations, such as request durations, in buckets
+// by their upper bounds, and keeps their sum.
+type Histogram struct{ m *metric }
+
+// NewHistogram registers a histogram with the given bucket upper
+// bounds, or DefaultBuckets when nil. The +Inf bucket is added
+// automatically. See NewCounter for labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	if len(buckets) > 0 && math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	if !slices.IsSorted(buckets) || len(slices.Compact(slices.Clone(buckets))) != len(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s must be increasing", name))
+	}
+	return &Histogram{r.register(name, help, histogramType, buckets, labels)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(labelValues)
+	i, _ := slices.BinarySearch(h.m.buckets, v)
+	if i < len(s.bucketCounts) {
+		s.bucketCounts[i]++
+	}
+	s.count++
+	s.value += v
+}
+
+// WriteTo writes every metric in the text exposition format, sorted by
+// name and then by label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(metrics, func(a, b *metric) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.writeTo(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (m *metric) writeTo(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := make([]*series, 0, len(m.series))
+	for _, s := range m.series {
+		all = append(all, s)
+	}
+	slices.SortFunc(all, func(a, b *series) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+
+	for _, s := range all {
+		if m.kind != histogramType {
+			writeSample(w, m.name, m.labels, s.labelValues, "", "", s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.bucketCounts[i]
+			writeSample(w, m.name+"_bucket", m.labels, s.labelValues, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, m.name+"_bucket", m.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, m.name+"_sum", m.labels, s.labelValues, "", "", s.value)
+		writeSample(w, m.name+"_count", m.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// writeSample writes a line with the labels and values, plus an extra
+// label if extraName isn't empty.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabelValue(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
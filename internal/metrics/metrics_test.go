@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exposition(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var sb strings.Builder
+	n, err := reg.WriteTo(&sb)
+	require.NoError(t, err)
+	assert.Equal(t, int64(sb.Len()), n)
+	return sb.String()
+}
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests.\nAll of them, C:\\ included.", "method", "path")
+	temperature := reg.NewGauge("temperature", "Degrees.")
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+	reg.NewCounter("unused_total", "Never touched.")
+
+	requests.Inc("GET", "/")
+	requests.Add(2, "GET", "/")
+	requests.Inc("POST", `/say "hi"`+"\n")
+	requests.Inc("GET", "/a")
+	temperature.Set(21.5)
+	temperature.Dec()
+	latency.Observe(0.05, "GET")
+	latency.Observe(0.1, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(3, "GET")
+
+	// Test: Text format, sorted, with escaping and cumulative buckets
+	assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 2
+latency_seconds_bucket{method="GET",le="1"} 3
+latency_seconds_bucket{method="GET",le="+Inf"} 4
+latency_seconds_sum{method="GET"} 3.65
+latency_seconds_count{method="GET"} 4
+# HELP requests_total Requests.\nAll of them, C:\\ included.
+# TYPE requests_total counter
+requests_total{method="GET",path="/"} 3
+requests_total{method="GET",path="/a"} 1
+requests_total{method="POST",path="/say \"hi\"\n"} 1
+# HELP temperature Degrees.
+# TYPE temperature gauge
+temperature 20.5
+# HELP unused_total Never touched.
+# TYPE unused_total counter
+unused_total 0
+`, exposition(t, reg))
+
+	// Test: Special values
+	temperature.Set(math.Inf(1))
+	assert.Contains(t, exposition(t, reg), "temperature +Inf\n")
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("ok_total", "OK.", "code")
+
+	// Test: Mistakes in the program panic
+	assert.Panics(t, func() { reg.NewCounter("ok_total", "Again.") })
+	assert.Panics(t, func() { reg.NewCounter("bad-name", "Bad.") })
+	assert.Panics(t, func() { reg.NewGauge("g", "Bad label.", "__reserved") })
+	assert.Panics(t, func() { reg.NewHistogram("h", "Bad label.", nil, "le") })
+	assert.Panics(t, func() { reg.NewHistogram("h2", "Unsorted.", []float64{1, 0.5}) })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "200") })
+
+	// Test: A trailing +Inf bucket is allowed
+	h := reg.NewHistogram("h3", "With +Inf.", []float64{1, math.Inf(1)})
+	h.Observe(2)
+	assert.Contains(t, exposition(t, reg), "h3_bucket{le=\"1\"} 0\nh3_bucket{le=\"+Inf\"} 1\n")
+}
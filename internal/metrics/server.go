@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"app/internal/server"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ServerOptions configures NewServerMetrics.
+type ServerOptions struct {
+	// Route names the route a request belongs to, for the route label.
+	// Every distinct value makes new series, so it should map requests
+	// onto a small fixed set, e.g. "/users/:id" rather than the path.
+	// Defaults to putting every request in DefaultRoute, since a label
+	// taken from the path would let clients make as many series as they
+	// like.
+	Route func(req *request.Request) string
+	// Buckets for the request duration histogram, in seconds. Defaults
+	// to DefaultBuckets.
+	Buckets []float64
+}
+
+// DefaultRoute is the route label of every request when
+// ServerOptions.Route is nil.
+const DefaultRoute = "other"
+
+// ServerMetrics instruments a server. Instrument wraps its handler, and
+// Options are passed to server.Serve for what happens before any
+// handler runs.
+type ServerMetrics struct {
+	route func(req *request.Request) string
+
+	requests    *Counter
+	duration    *Histogram
+	inFlight    *Gauge
+	connections *Gauge
+	bytesIn     *Counter
+	bytesOut    *Counter
+	parseErrors *Counter
+}
+
+// NewServerMetrics registers the server's metrics in reg:
+//   - http_requests_total{method,route,status}
+//   - http_request_duration_seconds{method,route}, the time the handler
+//     took
+//   - http_requests_in_flight
+//   - http_open_connections, not counting hijacked ones
+//   - http_request_decoded_body_bytes_total and
+//     http_response_body_bytes_total, counting message bodies only, not
+//     the status-line, headers or chunked framing. Requests are counted
+//     as the handler got them, after any Content-Encoding was undone by
+//     server.DecodeBody inside Instrument, and only if they were read.
+//     Responses are counted as sent, after compression, including the
+//     error responses to rejected requests, which matches the bytes the
+//     access log reports.
+//   - http_request_parse_errors_total{type}, for requests the server
+//     rejected before any handler ran
+func NewServerMetrics(reg *Registry, opts ServerOptions) *ServerMetrics {
+	route := opts.Route
+	if route == nil {
+		route = func(*request.Request) string {
+			return DefaultRoute
+		}
+	}
+	return &ServerMetrics{
+		route:       route,
+		requests:    reg.NewCounter("http_requests_total", "Requests answered by the handler.", "method", "route", "status"),
+		duration:    reg.NewHistogram("http_request_duration_seconds", "Time the handler took to answer requests.", opts.Buckets, "method", "route"),
+		inFlight:    reg.NewGauge("http_requests_in_flight", "Requests being handled."),
+		connections: reg.NewGauge("http_open_connections", "Open client connections, not counting hijacked ones."),
+		bytesIn:     reg.NewCounter("http_request_decoded_body_bytes_total", "Request body bytes read by the handler, after Content-Encoding was decoded."),
+		bytesOut:    reg.NewCounter("http_response_body_bytes_total", "Response body bytes sent, after compression, without headers or chunked framing."),
+		parseErrors: reg.NewCounter("http_request_parse_errors_total", "Requests rejected before reaching the handler, by reason.", "type"),
+	}
+}
+
+// Instrument wraps next to record its requests.
+func (m *ServerMetrics) Instrument(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		m.inFlight.Inc()
+		start := time.Now()
+		next(w, req)
+		elapsed := time.Since(start)
+		m.inFlight.Dec()
+
+		method := req.RequestLine.Method
+		route := m.route(req)
+		status := "hijacked"
+		if w.Status() != 0 {
+			status = strconv.Itoa(int(w.Status()))
+		}
+		m.requests.Inc(method, route, status)
+		m.duration.Observe(elapsed.Seconds(), method, route)
+		m.bytesIn.Add(float64(len(req.Body)))
+		m.bytesOut.Add(float64(w.BodyBytes()))
+	}
+}
+
+// Options returns the server options that report connections and
+// rejected requests, and the body bytes sent with their responses.
+func (m *ServerMetrics) Options() []server.Option {
+	return []server.Option{
+		server.WithConnState(func(_ net.Conn, state server.ConnState) {
+			switch state {
+			case server.StateNew:
+				m.connections.Inc()
+			case server.StateClosed, server.StateHijacked:
+				m.connections.Dec()
+			}
+		}),
+		server.WithRejectHook(func(r server.RejectedRequest) {
+			m.parseErrors.Inc(parseErrorType(r.StatusCode, r.Err))
+			m.bytesOut.Add(float64(r.BodyBytes))
+		}),
+	}
+}
+
+// parseErrorType sorts the reasons requests are rejected into a few
+// label values.
+func parseErrorType(statusCode response.StatusCode, err error) string {
+	switch {
+	case errors.Is(err, request.ErrVersionNotSupported):
+		return "version"
+	case errors.Is(err, request.ErrMissingHost), errors.Is(err, request.ErrMultipleHost), errors.Is(err, request.ErrInvalidHost):
+		return "host"
+	case errors.Is(err, request.ErrInvalidRequestTarget):
+		return "target"
+	case errors.Is(err, request.ErrInvalidContentLength), errors.Is(err, request.ErrConflictingContentLength):
+		return "content_length"
+	case errors.Is(err, request.ErrTransferEncodingWithContentLength), errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return "transfer_encoding"
+	}
+	switch statusCode {
+	case response.StatusNotImplemented:
+		return "method"
+	case response.StatusExpectationFailed:
+		return "expect"
+	case response.StatusPayloadTooLarge, response.StatusRequestHeaderFieldsTooLarge:
+		return "too_large"
+	}
+	if err == nil {
+		return "refused"
+	}
+	return "malformed"
+}
+
+// Handler returns a handler that serves the metrics in reg for GET and
+// HEAD requests.
+func Handler(reg *Registry) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		if method != "GET" && method != "HEAD" {
+			body := fmt.Appendf(nil, "Error: Method %s is not allowed", method)
+			h := response.GetDefaultHeaders(len(body))
+			h.Set("Allow", "GET, HEAD")
+			w.WriteStatusLine(response.StatusMethodNotAllowed)
+			w.WriteHeaders(h)
+			w.WriteBody(body)
+			return
+		}
+
+		var buf bytes.Buffer
+		reg.WriteTo(&buf)
+		h := response.GetDefaultHeaders(buf.Len())
+		h.Remove("Connection")
+		h.Replace("Content-Type", ContentType)
+		h.Set("Cache-Control", "no-store")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody(buf.Bytes())
+	}
+}
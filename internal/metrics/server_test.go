@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"app/internal/server"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip sends a raw request, reads the whole response and closes
+// the connection.
+func roundTrip(t *testing.T, s *server.Server, raw string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+func TestServerMetrics(t *testing.T) {
+	reg := NewRegistry()
+	m := NewServerMetrics(reg, ServerOptions{Route: func(req *request.Request) string {
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		return path
+	}})
+	release := make(chan struct{})
+	handler := func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/metrics":
+			Handler(reg)(w, req)
+		case "/slow":
+			<-release
+			fallthrough
+		default:
+			status := response.StatusOK
+			if req.RequestLine.Method == "GET" {
+				status = response.StatusNotFound
+			}
+			w.WriteStatusLine(status)
+			w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+			w.WriteBody(req.Body)
+		}
+	}
+	s, err := server.Serve(0, m.Instrument(handler), m.Options()...)
+	require.NoError(t, err)
+	defer s.Close()
+
+	roundTrip(t, s, "POST /echo?x=1 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	roundTrip(t, s, "GET /missing HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	// The error bodies the server sends itself count as response bytes.
+	rejectedBytes := 0
+	for _, raw := range []string{
+		"GET / HTTP/2.0\r\nHost: localhost\r\n\r\n",
+		"BREW / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+	} {
+		_, body, found := strings.Cut(roundTrip(t, s, raw), "\r\n\r\n")
+		require.True(t, found)
+		rejectedBytes += len(body)
+	}
+
+	slow, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer slow.Close()
+	_, err = slow.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	// Test: Requests, errors, bytes and what is in progress
+	resp := roundTrip(t, s, "GET /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+	assert.Contains(t, resp, "content-type: "+ContentType+"\r\n")
+	for _, line := range []string{
+		`http_requests_total{method="POST",route="/echo",status="200"} 1`,
+		`http_requests_total{method="GET",route="/missing",status="404"} 1`,
+		`http_request_duration_seconds_count{method="POST",route="/echo"} 1`,
+		`http_request_duration_seconds_bucket{method="POST",route="/echo",le="+Inf"} 1`,
+		`http_request_decoded_body_bytes_total 5`,
+		`http_response_body_bytes_total ` + strconv.Itoa(5+rejectedBytes),
+		`http_request_parse_errors_total{type="version"} 1`,
+		`http_request_parse_errors_total{type="method"} 1`,
+		`http_request_parse_errors_total{type="host"} 1`,
+		// The slow request and this one.
+		`http_requests_in_flight 2`,
+		`http_open_connections 2`,
+	} {
+		assert.Contains(t, resp, "\n"+line+"\n")
+	}
+
+	// Test: Finished requests leave the gauges
+	close(release)
+	_, err = io.ReadAll(slow)
+	require.NoError(t, err)
+	slow.Close()
+	time.Sleep(50 * time.Millisecond)
+	resp = roundTrip(t, s, "GET /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, resp, "\nhttp_requests_in_flight 1\n")
+	assert.Contains(t, resp, "\nhttp_open_connections 1\n")
+	assert.Contains(t, resp, `http_requests_total{method="GET",route="/slow",status="404"} 1`)
+
+	// Test: Only GET and HEAD
+	resp = roundTrip(t, s, "POST /metrics HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"), resp)
+}
+
+func TestServerMetricsDefaultRoute(t *testing.T) {
+	reg := NewRegistry()
+	m := NewServerMetrics(reg, ServerOptions{})
+	echo := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+		w.WriteBody(req.Body)
+	}
+	s, err := server.Serve(0, m.Instrument(server.DecodeBody(1<<20, echo)))
+	require.NoError(t, err)
+	defer s.Close()
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(strings.Repeat("a", 1000)))
+	gz.Close()
+	roundTrip(t, s, "POST /a?x=1 HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: "+strconv.Itoa(gzipped.Len())+"\r\nConnection: close\r\n\r\n"+gzipped.String())
+	roundTrip(t, s, "GET /b HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	var out bytes.Buffer
+	reg.WriteTo(&out)
+	// Test: Without Route every request gets the same label
+	assert.Contains(t, out.String(), `http_requests_total{method="POST",route="other",status="200"} 1`)
+	assert.Contains(t, out.String(), `http_requests_total{method="GET",route="other",status="200"} 1`)
+	// Test: Request bodies are counted after decoding
+	assert.Contains(t, out.String(), "\nhttp_request_decoded_body_bytes_total 1000\n")
+}
@@ -0,0 +1,62 @@
+package request
+
+import (
+	"strings"
+	"testing"
+)
+
+const benchCurlRequest = "GET /coffee HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/7.81.0\r\n" +
+	"Accept: */*\r\n" +
+	"\r\n"
+
+const benchBrowserRequest = "POST /api/orders?draft=1 HTTP/1.1\r\n" +
+	"Host: shop.example.com\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0\r\n" +
+	"Accept: application/json, text/plain, */*\r\n" +
+	"Accept-Language: en-US,en;q=0.5\r\n" +
+	"Accept-Encoding: gzip, deflate, br\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 27\r\n" +
+	"Origin: https://shop.example.com\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Referer: https://shop.example.com/cart\r\n" +
+	"Cookie: session=0123456789abcdef; theme=dark\r\n" +
+	"\r\n" +
+	`{"item":"coffee","qty":2}` + "\r\n"
+
+func benchmarkRequestFromReader(b *testing.B, raw string) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	reader := strings.NewReader(raw)
+	for b.Loop() {
+		reader.Reset(raw)
+		_, err := RequestFromReader(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRequestFromReaderCurl(b *testing.B) {
+	benchmarkRequestFromReader(b, benchCurlRequest)
+}
+
+func BenchmarkRequestFromReaderBrowser(b *testing.B) {
+	benchmarkRequestFromReader(b, benchBrowserRequest)
+}
+
+func BenchmarkParserFeed(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchBrowserRequest)))
+	data := []byte(benchBrowserRequest)
+	p := NewParser(ParseOptions{}, nil)
+	for b.Loop() {
+		p.Reset()
+		_, err := p.Feed(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+var ErrDecodedBodyTooLarge = errors.New("decoded body exceeds size limit")
+
+// SupportedEncodings lists the content-codings DecodeBody understands,
+// in the form used for an Accept-Encoding response field.
+const SupportedEncodings = "gzip, deflate"
+
+// DecodeBody replaces the body with its decoded form when the request
+// was sent with a Content-Encoding, and updates Content-Length to match.
+// Decoding stops with ErrDecodedBodyTooLarge once more than maxSize
+// bytes come out, so a small compressed body can't expand without
+// bound. Codings other than gzip, deflate and identity fail with
+// ErrUnsupportedEncoding. A body that hasn't been read yet is read
+// first, see ReadBody.
+func (r *Request) DecodeBody(maxSize int64) error {
+	encodingHeader, exists := r.Headers.Get("Content-Encoding")
+	if !exists {
+		return nil
+	}
+
+	err := r.ReadBody()
+	if err != nil {
+		return err
+	}
+
+	// Codings are listed in the order they were applied, so undo them
+	// from last to first.
+	codings := strings.Split(encodingHeader, ",")
+	body := r.Body
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+
+		decoded, err := decodeWith(coding, body, maxSize)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	r.Headers.Remove("Content-Encoding")
+	r.Headers.Replace("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func decodeWith(coding string, body []byte, maxSize int64) ([]byte, error) {
+	var decoder io.Reader
+	switch coding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading gzip body: %w", err)
+		}
+		defer gz.Close()
+		decoder = gz
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			// Some clients send raw deflate data without the zlib
+			// wrapper, so fall back to that.
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			decoder = fr
+		} else {
+			defer zr.Close()
+			decoder = zr
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(decoder, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding %s body: %w", coding, err)
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, fmt.Errorf("%w (%d bytes)", ErrDecodedBodyTooLarge, maxSize)
+	}
+
+	return decoded, nil
+}
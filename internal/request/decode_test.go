@@ -0,0 +1,92 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"app/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodedRequest(encoding string, body []byte) *Request {
+	h := headers.Headers{}
+	h.Set("Content-Encoding", encoding)
+	h.Set("Content-Length", "0")
+	return &Request{Headers: h, Body: body}
+}
+
+func TestDecodeBody(t *testing.T) {
+	text := []byte(strings.Repeat("decode me, ", 50))
+
+	// Test: gzip body
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write(text)
+	gz.Close()
+	r := encodedRequest("gzip", buf.Bytes())
+	require.NoError(t, r.DecodeBody(1024))
+	assert.Equal(t, text, r.Body)
+	assert.Equal(t, "550", r.Headers["content-length"])
+	assert.NotContains(t, r.Headers, "content-encoding")
+
+	// Test: zlib-wrapped deflate body
+	buf = &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write(text)
+	zw.Close()
+	r = encodedRequest("deflate", buf.Bytes())
+	require.NoError(t, r.DecodeBody(1024))
+	assert.Equal(t, text, r.Body)
+
+	// Test: Raw deflate body
+	buf = &bytes.Buffer{}
+	fw, _ := flate.NewWriter(buf, flate.DefaultCompression)
+	fw.Write(text)
+	fw.Close()
+	r = encodedRequest("deflate", buf.Bytes())
+	require.NoError(t, r.DecodeBody(1024))
+	assert.Equal(t, text, r.Body)
+
+	// Test: Stacked codings are undone in reverse order
+	inner := &bytes.Buffer{}
+	zw = zlib.NewWriter(inner)
+	zw.Write(text)
+	zw.Close()
+	buf = &bytes.Buffer{}
+	gz = gzip.NewWriter(buf)
+	gz.Write(inner.Bytes())
+	gz.Close()
+	r = encodedRequest("deflate, identity, gzip", buf.Bytes())
+	require.NoError(t, r.DecodeBody(1024))
+	assert.Equal(t, text, r.Body)
+
+	// Test: Zip bomb stops at the limit
+	buf = &bytes.Buffer{}
+	gz = gzip.NewWriter(buf)
+	gz.Write(make([]byte, 1<<20))
+	gz.Close()
+	r = encodedRequest("gzip", buf.Bytes())
+	err := r.DecodeBody(1024)
+	require.ErrorIs(t, err, ErrDecodedBodyTooLarge)
+
+	// Test: Unknown coding
+	r = encodedRequest("br", []byte("whatever"))
+	err = r.DecodeBody(1024)
+	require.ErrorIs(t, err, ErrUnsupportedEncoding)
+
+	// Test: Corrupt gzip data
+	r = encodedRequest("gzip", []byte("not gzip"))
+	err = r.DecodeBody(1024)
+	require.Error(t, err)
+
+	// Test: No Content-Encoding leaves the body untouched
+	r = &Request{Headers: headers.Headers{}, Body: []byte("plain")}
+	require.NoError(t, r.DecodeBody(1))
+	assert.Equal(t, "plain", string(r.Body))
+}
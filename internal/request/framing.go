@@ -0,0 +1,99 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidContentLength = errors.New("invalid Content-Length")
+var ErrConflictingContentLength = errors.New("conflicting Content-Length values")
+var ErrTransferEncodingWithContentLength = errors.New("both Transfer-Encoding and Content-Length are present")
+var ErrUnsupportedTransferEncoding = errors.New("Transfer-Encoding is not supported for requests")
+var ErrInvalidRequestTarget = errors.New("invalid request-target")
+
+// ParseOptions changes how requests are parsed.
+type ParseOptions struct {
+	// Strict rejects every request whose framing RFC 9112 leaves open
+	// to interpretation, since a proxy in front of the server might
+	// interpret it differently and smuggle a second request through:
+	// repeated or malformed Content-Length, obsolete line folding,
+	// control characters in field values or the request-target.
+	// Requests with a Transfer-Encoding are rejected either way.
+	Strict bool
+}
+
+// maxContentLengthDigits keeps strict Content-Length values well clear
+// of overflowing an int.
+const maxContentLengthDigits = 15
+
+// parseContentLength parses a Content-Length field value. Leniently, a
+// list of identical values (which is what a repeated field turns into)
+// is accepted as RFC 9112 section 6.3 allows; strict parsing only
+// accepts a single plain 1*DIGIT.
+func parseContentLength(value string, strict bool) (int, error) {
+	if strict {
+		if value == "" || len(value) > maxContentLengthDigits {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+		}
+		for i := 0; i < len(value); i++ {
+			if !isDigit(value[i]) {
+				return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+			}
+		}
+		return strconv.Atoi(value)
+	}
+
+	values := strings.Split(value, ",")
+	first := strings.TrimSpace(values[0])
+	for _, other := range values[1:] {
+		if strings.TrimSpace(other) != first {
+			return 0, fmt.Errorf("%w: %q", ErrConflictingContentLength, value)
+		}
+	}
+
+	contentLen, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"%w (%s): %w",
+			ErrInvalidContentLength,
+			value,
+			err,
+		)
+	}
+	if contentLen < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+	}
+	return contentLen, nil
+}
+
+// checkFraming runs once all headers are in and rejects requests with a
+// Transfer-Encoding, however leniently they are parsed. This parser
+// doesn't decode chunked request bodies, so a Transfer-Encoding would
+// otherwise be ignored and its body read as the next request.
+func (r *Request) checkFraming() error {
+	_, hasTransferEncoding := r.Headers.Get("Transfer-Encoding")
+	_, hasContentLength := r.Headers.Get("Content-Length")
+	if hasTransferEncoding && hasContentLength {
+		return ErrTransferEncodingWithContentLength
+	}
+	if hasTransferEncoding {
+		return ErrUnsupportedTransferEncoding
+	}
+	return nil
+}
+
+// isValidTarget reports whether a request-target is made of visible
+// ASCII only, as every form in RFC 9112 section 3.2 is.
+func isValidTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var ErrMissingHost = errors.New("missing Host header")
+var ErrMultipleHost = errors.New("multiple Host headers")
+var ErrInvalidHost = errors.New("invalid Host")
+
+// Host returns the authority (host and optional port) the request is
+// for. HTTP/1.1 requires exactly one Host field, HTTP/1.0 makes it
+// optional (RFC 9112 section 3.2). When the request-target is in
+// absolute-form, as sent to proxies, its authority wins over the field,
+// and for CONNECT the authority-form target is the host.
+func (r *Request) Host() (string, error) {
+	host, exists := r.Headers.Get("Host")
+	if !exists && r.RequestLine.HttpVersion != "1.0" {
+		return "", ErrMissingHost
+	}
+	// Host can't contain a comma, so one means the field was repeated
+	// and joined by Headers.Set.
+	if strings.Contains(host, ",") {
+		return "", fmt.Errorf("%w: %q", ErrMultipleHost, host)
+	}
+	if !isValidHost(host) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidHost, host)
+	}
+
+	if r.RequestLine.Method == "CONNECT" {
+		return r.RequestLine.RequestTarget, nil
+	}
+	if authority, ok := targetAuthority(r.RequestLine.RequestTarget); ok {
+		return authority, nil
+	}
+	return host, nil
+}
+
+// targetAuthority extracts the authority from an absolute-form target
+// like http://example.com:8080/path.
+func targetAuthority(target string) (string, bool) {
+	_, rest, found := strings.Cut(target, "://")
+	if !found || strings.HasPrefix(target, "/") {
+		return "", false
+	}
+	authority, _, _ := strings.Cut(rest, "/")
+	authority, _, _ = strings.Cut(authority, "?")
+	if authority == "" || !isValidHost(authority) {
+		return "", false
+	}
+	return authority, true
+}
+
+// isValidHost checks for the characters allowed in uri-host [":" port]
+// (RFC 3986 section 3.2.2), including IPv6 literals in brackets.
+func isValidHost(host string) bool {
+	for i := 0; i < len(host); i++ {
+		char := host[i]
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', isDigit(char):
+		case strings.IndexByte("-._~!$&'()*+;=%:[]", char) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isAuthorityForm checks a CONNECT target, uri-host ":" port with the
+// port required (RFC 9112 section 3.2.3).
+func isAuthorityForm(target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" || !isValidHost(target) {
+		return false
+	}
+	for i := 0; i < len(port); i++ {
+		if !isDigit(port[i]) {
+			return false
+		}
+	}
+	number, err := strconv.Atoi(port)
+	return err == nil && number > 0 && number <= 65535
+}
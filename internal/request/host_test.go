@@ -0,0 +1,72 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHost(t *testing.T) {
+	parse := func(raw string) *Request {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Plain Host
+	host, err := parse("GET / HTTP/1.1\r\nHost: example.com:8080\r\n\r\n").Host()
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:8080", host)
+
+	// Test: IPv6 literal
+	host, err = parse("GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n").Host()
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:8080", host)
+
+	// Test: Missing Host on HTTP/1.1
+	_, err = parse("GET / HTTP/1.1\r\n\r\n").Host()
+	require.ErrorIs(t, err, ErrMissingHost)
+
+	// Test: Missing Host on HTTP/1.0 is fine
+	host, err = parse("GET / HTTP/1.0\r\n\r\n").Host()
+	require.NoError(t, err)
+	assert.Equal(t, "", host)
+
+	// Test: Repeated Host
+	_, err = parse("GET / HTTP/1.1\r\nHost: a.com\r\nHost: b.com\r\n\r\n").Host()
+	require.ErrorIs(t, err, ErrMultipleHost)
+
+	// Test: Invalid characters
+	_, err = parse("GET / HTTP/1.1\r\nHost: a.com/evil\r\n\r\n").Host()
+	require.ErrorIs(t, err, ErrInvalidHost)
+	_, err = parse("GET / HTTP/1.1\r\nHost: a com\r\n\r\n").Host()
+	require.ErrorIs(t, err, ErrInvalidHost)
+
+	// Test: Absolute-form target wins over Host
+	host, err = parse("GET http://b.com:81/path?q=1 HTTP/1.1\r\nHost: a.com\r\n\r\n").Host()
+	require.NoError(t, err)
+	assert.Equal(t, "b.com:81", host)
+
+	// Test: CONNECT targets are the host
+	host, err = parse("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n").Host()
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", host)
+	host, err = parse("CONNECT [::1]:8443 HTTP/1.1\r\nHost: [::1]:8443\r\n\r\n").Host()
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:8443", host)
+
+	// Test: CONNECT needs a host and port and nothing else
+	for _, target := range []string{"example.com", "example.com:", ":443", "example.com:https", "example.com:70000", "/path", "http://example.com:443/", "user@example.com:443"} {
+		_, err = RequestFromReader(strings.NewReader("CONNECT " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		assert.Error(t, err, target)
+	}
+
+	// Test: Repeated Host is rejected outright by strict parsing
+	_, err = RequestFromReaderWithOptions(
+		strings.NewReader("GET / HTTP/1.1\r\nHost: a.com\r\nHost: a.com\r\n\r\n"),
+		ParseOptions{Strict: true},
+	)
+	require.Error(t, err)
+}
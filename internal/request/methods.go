@@ -0,0 +1,55 @@
+package request
+
+import (
+	"app/internal/headers"
+	"fmt"
+	"sync"
+)
+
+// Method describes the properties of a request method (RFC 9110
+// section 9.2).
+type Method struct {
+	Name string
+	// Safe methods are read-only from the client's point of view.
+	Safe bool
+	// Idempotent methods can be retried without changing the outcome.
+	Idempotent bool
+	// AllowsBody is set for methods whose request content has defined
+	// semantics. Others may still carry a body, it just means nothing.
+	AllowsBody bool
+}
+
+var methodsMu sync.RWMutex
+var knownMethods = map[string]Method{
+	"GET":     {Name: "GET", Safe: true, Idempotent: true},
+	"HEAD":    {Name: "HEAD", Safe: true, Idempotent: true},
+	"OPTIONS": {Name: "OPTIONS", Safe: true, Idempotent: true, AllowsBody: true},
+	"TRACE":   {Name: "TRACE", Safe: true, Idempotent: true},
+	"PUT":     {Name: "PUT", Idempotent: true, AllowsBody: true},
+	"DELETE":  {Name: "DELETE", Idempotent: true},
+	"POST":    {Name: "POST", AllowsBody: true},
+	"PATCH":   {Name: "PATCH", AllowsBody: true},
+	"CONNECT": {Name: "CONNECT"},
+}
+
+// RegisterMethod adds an extension method, such as WebDAV's PROPFIND,
+// to the registry or replaces the properties of a known one. Method
+// names are case-sensitive tokens.
+func RegisterMethod(m Method) error {
+	if !headers.IsToken(m.Name) {
+		return fmt.Errorf(`Invalid method name: "%s". Method must be a token.`, m.Name)
+	}
+
+	methodsMu.Lock()
+	defer methodsMu.Unlock()
+	knownMethods[m.Name] = m
+	return nil
+}
+
+// LookupMethod returns the registered properties of a method.
+func LookupMethod(name string) (Method, bool) {
+	methodsMu.RLock()
+	defer methodsMu.RUnlock()
+	m, ok := knownMethods[name]
+	return m, ok
+}
@@ -0,0 +1,55 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodParse(t *testing.T) {
+	// Test: Extension methods are any token
+	r, err := RequestFromReader(strings.NewReader("PROPFIND /files HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "PROPFIND", r.RequestLine.Method)
+
+	r, err = RequestFromReader(strings.NewReader("get-1 / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "get-1", r.RequestLine.Method)
+
+	// Test: Non-token characters in the method
+	_, err = RequestFromReader(strings.NewReader("G@T / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.Error(t, err)
+
+	_, err = RequestFromReader(strings.NewReader("GÉT / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.Error(t, err)
+}
+
+func TestMethodRegistry(t *testing.T) {
+	// Test: Standard methods
+	m, ok := LookupMethod("GET")
+	require.True(t, ok)
+	assert.True(t, m.Safe)
+	assert.True(t, m.Idempotent)
+	assert.False(t, m.AllowsBody)
+
+	m, ok = LookupMethod("POST")
+	require.True(t, ok)
+	assert.False(t, m.Safe)
+	assert.False(t, m.Idempotent)
+	assert.True(t, m.AllowsBody)
+
+	// Test: Methods are case-sensitive
+	_, ok = LookupMethod("get")
+	assert.False(t, ok)
+
+	// Test: Registering an extension method
+	require.NoError(t, RegisterMethod(Method{Name: "MKCOL", Idempotent: true}))
+	m, ok = LookupMethod("MKCOL")
+	require.True(t, ok)
+	assert.True(t, m.Idempotent)
+
+	// Test: Invalid method name
+	require.Error(t, RegisterMethod(Method{Name: "BAD METHOD"}))
+}
@@ -0,0 +1,90 @@
+package request
+
+import (
+	"app/internal/headers"
+	"fmt"
+)
+
+type EventType int
+
+const (
+	// EventRequestLine is sent once the request-line is parsed.
+	EventRequestLine EventType = iota
+	// EventHeader is sent for every field line.
+	EventHeader
+	// EventHeadersDone is sent after the last field line, before any
+	// of the body.
+	EventHeadersDone
+	// EventBody is sent for every piece of the body as it arrives.
+	EventBody
+	// EventDone is sent once the whole request is parsed.
+	EventDone
+)
+
+// Event is what a Parser reports as it makes progress. Only the field
+// matching Type is set.
+type Event struct {
+	Type        EventType
+	RequestLine RequestLine
+	Field       headers.Field
+	// Body points into the data passed to Feed and is only valid
+	// until the event handler returns.
+	Body []byte
+}
+
+// Parser is a push-based request parser: instead of reading from an
+// io.Reader, it is fed bytes as they become available, which suits
+// event loops, test harnesses and replaying captured traffic. It runs
+// the same state machine as RequestFromReader.
+type Parser struct {
+	opts    ParseOptions
+	onEvent func(Event) error
+	req     *Request
+}
+
+// NewParser returns a Parser that calls onEvent (which may be nil) for
+// every step of the request. An error returned from onEvent stops
+// parsing and is returned from Feed.
+func NewParser(opts ParseOptions, onEvent func(Event) error) *Parser {
+	p := &Parser{opts: opts, onEvent: onEvent}
+	p.Reset()
+	return p
+}
+
+// Feed parses as much of data as it can and returns how many bytes it
+// consumed. Bytes that weren't consumed, such as an incomplete line,
+// must be passed again at the start of the next call along with more
+// data. Once the request is done, Feed consumes nothing more; the rest
+// belongs to the next request, see Reset.
+func (p *Parser) Feed(data []byte) (consumed int, err error) {
+	if p.req.state == requestDone {
+		return 0, nil
+	}
+
+	consumed, err = p.req.parse(data, requestDone)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing request: %w", err)
+	}
+	return consumed, nil
+}
+
+// Done reports whether a whole request has been parsed.
+func (p *Parser) Done() bool {
+	return p.req.state == requestDone
+}
+
+// Request returns the request parsed so far. Its Body holds the body
+// bytes received until now.
+func (p *Parser) Request() *Request {
+	return p.req
+}
+
+// Reset starts parsing a new request, for the next one on a persistent
+// connection.
+func (p *Parser) Reset() {
+	p.req = &Request{
+		Headers: headers.Headers{},
+		strict:  p.opts.Strict,
+		onEvent: p.onEvent,
+	}
+}
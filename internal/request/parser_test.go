@@ -0,0 +1,113 @@
+package request
+
+import (
+	"errors"
+	"testing"
+
+	"app/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feed pushes data through p in pieces of at most size bytes, the way
+// an event loop would, keeping unconsumed bytes for the next call.
+func feed(t *testing.T, p *Parser, data string, size int) string {
+	t.Helper()
+	pending := []byte{}
+	for len(data) > 0 && !p.Done() {
+		n := min(size, len(data))
+		pending = append(pending, data[:n]...)
+		data = data[n:]
+
+		consumed, err := p.Feed(pending)
+		require.NoError(t, err)
+		pending = pending[consumed:]
+	}
+	return string(pending) + data
+}
+
+func TestParser(t *testing.T) {
+	raw := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 13\r\n" +
+		"\r\n" +
+		"hello world!\n"
+
+	// Test: Events in order, fed one byte at a time
+	events := []Event{}
+	body := []byte{}
+	p := NewParser(ParseOptions{}, func(e Event) error {
+		if e.Type == EventBody {
+			body = append(body, e.Body...)
+			e.Body = nil
+		}
+		events = append(events, e)
+		return nil
+	})
+	rest := feed(t, p, raw, 1)
+	assert.Equal(t, "", rest)
+	require.True(t, p.Done())
+
+	require.Greater(t, len(events), 5)
+	assert.Equal(t, EventRequestLine, events[0].Type)
+	assert.Equal(t, "POST", events[0].RequestLine.Method)
+	assert.Equal(t, Event{Type: EventHeader, Field: headers.Field{Name: "Host", Value: "localhost:42069"}}, events[1])
+	assert.Equal(t, Event{Type: EventHeader, Field: headers.Field{Name: "Content-Length", Value: "13"}}, events[2])
+	assert.Equal(t, EventHeadersDone, events[3].Type)
+	for _, e := range events[4 : len(events)-1] {
+		assert.Equal(t, EventBody, e.Type)
+	}
+	assert.Equal(t, EventDone, events[len(events)-1].Type)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, "hello world!\n", string(p.Request().Body))
+
+	// Test: Whole request at once, pipelined with the next one
+	events = events[:0]
+	p = NewParser(ParseOptions{}, func(e Event) error {
+		events = append(events, e)
+		return nil
+	})
+	data := []byte(raw + "GET /next HTTP/1.1\r\nHost: a\r\n\r\n")
+	consumed, err := p.Feed(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(raw), consumed)
+	assert.True(t, p.Done())
+	assert.Len(t, events, 6)
+
+	// Done parser consumes nothing
+	n, err := p.Feed(data[consumed:])
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	p.Reset()
+	consumed2, err := p.Feed(data[consumed:])
+	require.NoError(t, err)
+	assert.Equal(t, len(data)-consumed, consumed2)
+	assert.True(t, p.Done())
+	assert.Equal(t, "/next", p.Request().RequestLine.RequestTarget)
+	assert.Nil(t, p.Request().Body)
+
+	// Test: Handler errors stop parsing
+	stop := errors.New("stop")
+	p = NewParser(ParseOptions{}, func(e Event) error {
+		if e.Type == EventHeader {
+			return stop
+		}
+		return nil
+	})
+	_, err = p.Feed([]byte(raw))
+	require.ErrorIs(t, err, stop)
+
+	// Test: Strict options apply
+	p = NewParser(ParseOptions{Strict: true}, nil)
+	_, err = p.Feed([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +1\r\n\r\nx"))
+	require.ErrorIs(t, err, ErrInvalidContentLength)
+
+	// Test: Incomplete lines are left unconsumed
+	p = NewParser(ParseOptions{}, nil)
+	consumed, err = p.Feed([]byte("GET / HT"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, consumed)
+	assert.False(t, p.Done())
+}
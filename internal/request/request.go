@@ -2,13 +2,25 @@ package request
 
 import (
 	"app/internal/headers"
+	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"log/slog"
 	"strings"
+	"sync"
 )
 
+// ErrVersionNotSupported is returned for requests with an HTTP major
+// version other than 1, which should be answered with 505.
+var ErrVersionNotSupported = errors.New("HTTP version not supported")
+
+// ErrHeaderTooLarge is returned for requests whose request-line and
+// header fields take more than maxHeaderBytes, which should be answered
+// with 431.
+var ErrHeaderTooLarge = errors.New("request header section too large")
+
 type requestState int
 
 const (
@@ -22,7 +34,39 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
-	state       requestState
+	// RemoteAddr is the client's network address, set by the server.
+	RemoteAddr string
+	// TLS describes the connection for requests received over TLS, set
+	// by the server. It is nil for plain HTTP.
+	TLS *tls.ConnectionState
+	// Logger logs with the ID and remote address of the connection, set
+	// by the server. Log falls back to the default logger for requests
+	// that didn't come from one.
+	Logger *slog.Logger
+	state  requestState
+	strict bool
+
+	// Body framing, set once the headers are done.
+	hasBody       bool
+	bodyRemaining int
+	// Set when driven by a Parser, see parser.go.
+	onEvent func(Event) error
+
+	// Where the rest of the request comes from when the body is read
+	// after the headers, see RequestHeadersFromReader.
+	source *requestSource
+	// Called once before the body is first read from source.
+	beforeBodyRead func() error
+}
+
+// requestSource holds the reader and the bytes read from it that have
+// not been parsed yet.
+type requestSource struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	// Bytes of the request-line and header fields parsed so far.
+	headerBytes int
 }
 
 type RequestLine struct {
@@ -31,9 +75,9 @@ type RequestLine struct {
 	Method        string
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+func (r *Request) parse(data []byte, until requestState) (int, error) {
 	totalBytesParsed := 0
-	for r.state != requestDone {
+	for r.state < until {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
@@ -56,55 +100,73 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 
+		if bytesParsed > 0 && r.strict && !isValidTarget(requestLine.RequestTarget) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidRequestTarget, requestLine.RequestTarget)
+		}
+
 		if bytesParsed > 0 {
-			r.RequestLine = *requestLine
+			r.RequestLine = requestLine
 			r.state = requestParsingHeaders
+			err := r.emit(Event{Type: EventRequestLine, RequestLine: r.RequestLine})
+			if err != nil {
+				return 0, err
+			}
 		}
 
 		return bytesParsed, nil
 	case requestParsingHeaders:
-		bytesParsed, done, err := r.Headers.Parse(data)
+		field, bytesParsed, done, err := r.Headers.ParseField(data, r.strict)
 
 		if err != nil {
 			return 0, err
 		}
 
 		if done {
+			err := r.startBody()
+			if err != nil {
+				return 0, err
+			}
 			r.state = requestParsingBody
+			return bytesParsed, r.emit(Event{Type: EventHeadersDone})
+		}
+
+		if bytesParsed > 0 {
+			err := r.emit(Event{Type: EventHeader, Field: field})
+			if err != nil {
+				return 0, err
+			}
 		}
 
 		return bytesParsed, nil
 	case requestParsingBody:
-		contentHeader, exists := r.Headers.Get("Content-Length")
-		if !exists {
+		if r.hasBody && r.Body == nil {
+			r.Body = make([]byte, 0, min(r.bodyRemaining, maxBodyPrealloc))
+		}
+
+		if r.bodyRemaining == 0 {
 			r.state = requestDone
+			return 0, r.emit(Event{Type: EventDone})
+		}
+
+		// Anything past Content-Length belongs to the next
+		// request on a persistent connection, see Buffered.
+		chunk := data[:min(len(data), r.bodyRemaining)]
+		if len(chunk) == 0 {
 			return 0, nil
 		}
+		r.Body = append(r.Body, chunk...)
+		r.bodyRemaining -= len(chunk)
 
-		contentLen, err := strconv.Atoi(contentHeader)
+		err := r.emit(Event{Type: EventBody, Body: chunk})
 		if err != nil {
-			return 0, fmt.Errorf(
-				"Failed to parse Content-Length (%s): %w",
-				contentHeader,
-				err,
-			)
+			return 0, err
 		}
-
-		if len(data) == contentLen {
+		if r.bodyRemaining == 0 {
 			r.state = requestDone
-			r.Body = make([]byte, len(data))
-			copy(r.Body, data)
-			return contentLen, nil
-		}
-
-		if len(data) > contentLen {
-			return 0, fmt.Errorf(
-				"Body is larger than reported Content-Length (%d)",
-				contentLen,
-			)
+			return len(chunk), r.emit(Event{Type: EventDone})
 		}
 
-		return 0, nil
+		return len(chunk), nil
 	case requestDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state.")
 	default:
@@ -112,113 +174,368 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
-const bufferSize int = 8
+// Upper bound for preallocating the body from Content-Length, so a
+// huge claimed length can't allocate memory before any data arrives.
+const maxBodyPrealloc = 64 << 10
+
+// startBody checks the framing headers once they are all in and works
+// out how many body bytes to expect.
+func (r *Request) startBody() error {
+	err := r.checkFraming()
+	if err != nil {
+		return err
+	}
+
+	contentHeader, exists := r.Headers.Get("Content-Length")
+	if !exists {
+		return nil
+	}
+
+	contentLen, err := parseContentLength(contentHeader, r.strict)
+	if err != nil {
+		return err
+	}
+
+	r.hasBody = true
+	r.bodyRemaining = contentLen
+	return nil
+}
+
+func (r *Request) emit(event Event) error {
+	if r.onEvent == nil {
+		return nil
+	}
+	return r.onEvent(event)
+}
+
+// Read buffers are pooled, since each request only needs one until it
+// is parsed. Most requests fit without growing it.
+const bufferSize int = 4096
+
+// Most the request-line and header fields may take up together, so a
+// client can't make the buffer grow without end. The same as the
+// client's limit on a single response line.
+const maxHeaderBytes = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	return RequestFromReaderWithOptions(reader, ParseOptions{})
+}
+
+// RequestFromReaderWithOptions is RequestFromReader with control over
+// how strictly the request is parsed.
+func RequestFromReaderWithOptions(reader io.Reader, opts ParseOptions) (*Request, error) {
+	newRequest, err := RequestHeadersFromReaderWithOptions(reader, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = newRequest.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRequest, nil
+}
+
+// RequestHeadersFromReader parses the request-line and headers but
+// leaves the body unread, so the caller can look at the request before
+// the body is transferred. ReadBody reads the rest from the same reader.
+func RequestHeadersFromReader(reader io.Reader) (*Request, error) {
+	return RequestHeadersFromReaderWithOptions(reader, ParseOptions{})
+}
 
+// RequestHeadersFromReaderWithOptions is RequestHeadersFromReader with
+// control over how strictly the request is parsed.
+func RequestHeadersFromReaderWithOptions(reader io.Reader, opts ParseOptions) (*Request, error) {
 	newRequest := &Request{
 		Headers: headers.Headers{},
+		strict:  opts.Strict,
+		source: &requestSource{
+			reader: reader,
+			buf:    *bufferPool.Get().(*[]byte),
+		},
+	}
+
+	err := newRequest.readUntil(requestParsingBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRequest, nil
+}
+
+// ReadBody reads and parses the body of a request returned by
+// RequestHeadersFromReader into Body. It does nothing if the body has
+// already been read, or if the Request was built by hand and so has no
+// reader to read from.
+func (r *Request) ReadBody() error {
+	if r.state == requestDone || r.source == nil {
+		return nil
+	}
+
+	return r.readUntil(requestDone)
+}
+
+// OnBodyRead registers a hook that runs once, right before the body is
+// first read from the underlying reader. The server uses it to send
+// 100 Continue only when a handler actually wants the body. If the hook
+// fails, ReadBody returns its error.
+func (r *Request) OnBodyRead(hook func() error) {
+	r.beforeBodyRead = hook
+}
+
+// BodyRead reports whether the whole request, body included, has been
+// parsed.
+func (r *Request) BodyRead() bool {
+	return r.state == requestDone
+}
+
+// Buffered returns the bytes that were read from the reader but are not
+// part of what has been parsed so far: the start of the body if it
+// hasn't been read yet, or of the next request on the connection.
+func (r *Request) Buffered() []byte {
+	if r.source == nil {
+		return nil
+	}
+	return r.source.buf[:r.source.readToIndex]
+}
+
+// Log returns the request's Logger, or slog.Default() if it has none.
+func (r *Request) Log() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
 	}
+	return r.Logger
+}
 
-	for newRequest.state != requestDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+// KeepAlive reports whether the client wants the connection kept open
+// after the response: by default for HTTP/1.1, and only when asked for
+// with Connection: keep-alive for HTTP/1.0.
+func (r *Request) KeepAlive() bool {
+	connection, _ := r.Headers.Get("Connection")
+	keepAliveRequested := false
+	for _, option := range strings.Split(connection, ",") {
+		option = strings.TrimSpace(option)
+		if strings.EqualFold(option, "close") {
+			return false
+		}
+		if strings.EqualFold(option, "keep-alive") {
+			keepAliveRequested = true
 		}
+	}
 
-		// Reader can read to a SUBSLICE, very cool
-		readSize, err := reader.Read(buf[readToIndex:])
+	if r.RequestLine.HttpVersion == "1.0" {
+		return keepAliveRequested
+	}
+	return true
+}
+
+// ExpectsContinue reports whether the client sent Expect: 100-continue
+// and is waiting for an interim response before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	expect, exists := r.Headers.Get("Expect")
+	return exists && strings.EqualFold(strings.TrimSpace(expect), "100-continue")
+}
+
+// readUntil parses buffered data and reads more from the source until
+// the request reaches the target state.
+func (r *Request) readUntil(target requestState) error {
+	src := r.source
+
+	for r.state < target {
+		inHeaders := r.state < requestParsingBody
+		numBytesParsed, err := r.parse(src.buf[:src.readToIndex], target)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if newRequest.state != requestDone {
-					return nil, fmt.Errorf("Incomplete request, in state: %d, read n bytes on EOF: %d", newRequest.state, readSize)
-				}
-				break
+			return err
+		}
+		if inHeaders {
+			src.headerBytes += numBytesParsed
+		}
+
+		// Shifting data out to reuse buffer, in two
+		// simple lines. Also very cool.
+		copy(src.buf, src.buf[numBytesParsed:src.readToIndex])
+		src.readToIndex -= numBytesParsed
+
+		if r.state == requestDone {
+			src.release()
+		}
+		if r.state >= target {
+			break
+		}
+
+		if r.state == requestParsingBody && r.beforeBodyRead != nil {
+			hook := r.beforeBodyRead
+			r.beforeBodyRead = nil
+			err := hook()
+			if err != nil {
+				return err
 			}
-			return nil, err
 		}
 
-		readToIndex += readSize
+		if src.readToIndex >= len(src.buf) {
+			newBuf := make([]byte, len(src.buf)*2)
+			copy(newBuf, src.buf)
+			src.buf = newBuf
+		}
 
-		numBytesParsed, err := newRequest.parse(buf[:readToIndex])
+		// Reader can read to a SUBSLICE, very cool
+		readInto := src.buf[src.readToIndex:]
+		if r.state < requestParsingBody {
+			// Never read past the limit while the headers aren't done.
+			allowed := maxHeaderBytes - src.headerBytes - src.readToIndex
+			if allowed <= 0 {
+				return fmt.Errorf("%w: over %d bytes", ErrHeaderTooLarge, maxHeaderBytes)
+			}
+			if len(readInto) > allowed {
+				readInto = readInto[:allowed]
+			}
+		}
+		readSize, err := src.reader.Read(readInto)
 		if err != nil {
-			return nil, err
+			if errors.Is(err, io.EOF) {
+				if r.state == requestInitialized && src.readToIndex == 0 && readSize == 0 {
+					// Nothing was sent, e.g. an idle persistent
+					// connection being closed by the client.
+					return io.EOF
+				}
+				return fmt.Errorf("Incomplete request, in state: %d, read n bytes on EOF: %d", r.state, readSize)
+			}
+			return err
 		}
 
-		// Shifting data out to reuse buffer, in two
-		// simple lines. Also very cool.
-		copy(buf, buf[numBytesParsed:])
-		readToIndex -= numBytesParsed
+		src.readToIndex += readSize
 	}
 
-	return newRequest, nil
+	return nil
+}
+
+// release hands the read buffer back to the pool once the request is
+// done, keeping a copy of what was read past its end (usually nothing)
+// for Buffered.
+func (src *requestSource) release() {
+	leftover := []byte(nil)
+	if src.readToIndex > 0 {
+		leftover = make([]byte, src.readToIndex)
+		copy(leftover, src.buf)
+	}
+	if cap(src.buf) == bufferSize {
+		buf := src.buf[:bufferSize]
+		bufferPool.Put(&buf)
+	}
+	src.buf = leftover
 }
 
 // parseRequestLine parses an HTTP request line from a string of bytes.
 // pareRequestLine returns the RequestLine, bytes consumed, and optional error.
-func parseRequestLine(request []byte) (*RequestLine, int, error) {
-	requestText := string(request)
-
-	crlfIndex := strings.Index(requestText, "\r\n")
+// It works on the bytes directly and only converts the parts it keeps,
+// since it is called again on the whole buffer after every read until
+// the line is complete.
+func parseRequestLine(request []byte) (RequestLine, int, error) {
+	crlfIndex := bytes.Index(request, []byte{'\r', '\n'})
 	if crlfIndex == -1 {
-		return nil, 0, nil // No CRLF, so need to read more.
+		return RequestLine{}, 0, nil // No CRLF, so need to read more.
 	}
 
-	requestText = requestText[:crlfIndex]
+	requestText := request[:crlfIndex]
 
-	requestParts := strings.Split(requestText, " ")
-	if len(requestParts) != 3 {
-		return nil, 0, errors.New("Invalid request line.")
+	methodEnd := bytes.IndexByte(requestText, ' ')
+	targetEnd := -1
+	if methodEnd != -1 {
+		targetEnd = bytes.IndexByte(requestText[methodEnd+1:], ' ')
 	}
-
-	method := requestParts[0]
-	if !isCapitalOnly(method) {
-		return nil, 0, fmt.Errorf(
-			`Invalid request method: "%s". Method may only contain captial letters.`,
-			method,
+	if methodEnd == -1 || targetEnd == -1 || bytes.IndexByte(requestText[methodEnd+1+targetEnd+1:], ' ') != -1 {
+		return RequestLine{}, 0, errors.New("Invalid request line.")
+	}
+	targetEnd += methodEnd + 1
+
+	// Any token is syntactically a method, whether the server
+	// implements it is decided later, see LookupMethod.
+	methodBytes := requestText[:methodEnd]
+	if !headers.IsTokenBytes(methodBytes) {
+		return RequestLine{}, 0, fmt.Errorf(
+			`Invalid request method: "%s". Method must be a token.`,
+			methodBytes,
 		)
 	}
+	method := internMethod(methodBytes)
 
-	requestTarget := requestParts[1]
+	requestTarget := string(requestText[methodEnd+1 : targetEnd])
+	if method == "CONNECT" && !isAuthorityForm(requestTarget) {
+		return RequestLine{}, 0, fmt.Errorf(
+			`Invalid CONNECT target: "%s". Required format: host ":" port`,
+			requestTarget,
+		)
+	}
 
-	httpVersion := requestParts[2]
-	httpVersionParts := strings.Split(httpVersion, "/")
-	if len(httpVersionParts) != 2 {
-		return nil, 0, fmt.Errorf(
+	httpVersion := requestText[targetEnd+1:]
+	versionName, versionNumberBytes, found := bytes.Cut(httpVersion, []byte{'/'})
+	if !found || bytes.IndexByte(versionNumberBytes, '/') != -1 {
+		return RequestLine{}, 0, fmt.Errorf(
 			`Invalid HTTP version: "%s". Required format: HTTP-name "/" DIGIT "." DIGIT`,
 			httpVersion,
 		)
 	}
-	if httpVersionParts[0] != "HTTP" {
-		return nil, 0, fmt.Errorf(
-			`Invalid HTTP version name: "%s". Only HTTP/1.1 is supported.`,
-			httpVersionParts[0],
+	if string(versionName) != "HTTP" {
+		return RequestLine{}, 0, fmt.Errorf(
+			`Invalid HTTP version name: "%s". Only HTTP/1.0 and HTTP/1.1 are supported.`,
+			versionName,
 		)
 	}
-	if httpVersionParts[1] != "1.1" {
-		return nil, 0, fmt.Errorf(
-			`Invalid HTTP version number: "%s". Only HTTP/1.1 is supported.`,
-			httpVersionParts[1],
+	if len(versionNumberBytes) != 3 || !isDigit(versionNumberBytes[0]) || versionNumberBytes[1] != '.' || !isDigit(versionNumberBytes[2]) {
+		return RequestLine{}, 0, fmt.Errorf(
+			`Invalid HTTP version number: "%s". Required format: DIGIT "." DIGIT`,
+			versionNumberBytes,
 		)
 	}
+	// Any HTTP/1.x is understood, later minor versions are answered
+	// as HTTP/1.1 (RFC 9110 section 6.2).
+	if versionNumberBytes[0] != '1' {
+		return RequestLine{}, 0, fmt.Errorf(
+			`%w: "%s". Only HTTP/1.0 and HTTP/1.1 are supported.`,
+			ErrVersionNotSupported,
+			versionNumberBytes,
+		)
+	}
+
+	var versionNumber string
+	switch string(versionNumberBytes) {
+	case "1.1":
+		versionNumber = "1.1"
+	case "1.0":
+		versionNumber = "1.0"
+	default:
+		versionNumber = string(versionNumberBytes)
+	}
 
-	return &RequestLine{
+	return RequestLine{
 			Method:        method,
 			RequestTarget: requestTarget,
-			HttpVersion:   httpVersionParts[1],
+			HttpVersion:   versionNumber,
 		},
 		len(requestText) + 2, // + 2 for CRLF
 		nil
 }
 
-func isCapitalOnly(text string) bool {
-	for _, char := range text {
-		if char < 'A' || char > 'Z' {
-			return false
-		}
+// internMethod returns the registered name for known methods, so the
+// common ones don't allocate a new string per request.
+func internMethod(method []byte) string {
+	methodsMu.RLock()
+	m, known := knownMethods[string(method)]
+	methodsMu.RUnlock()
+	if known {
+		return m.Name
 	}
-	return true
+	return string(method)
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
 }
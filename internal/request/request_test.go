@@ -62,7 +62,76 @@ func TestRequestLineParse(t *testing.T) {
 
 	// Test: Invalid version number in Request line
 	_, err = RequestFromReader(strings.NewReader("OPTIONS /prime/rib HTTP/2.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
+	require.ErrorIs(t, err, ErrVersionNotSupported)
+
+	// Test: Malformed version number in Request line
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.10\r\nHost: localhost:42069\r\n\r\n"))
 	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrVersionNotSupported)
+
+	// Test: HTTP/1.0 Request line without Host
+	r, err = RequestFromReader(strings.NewReader("GET /health HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
+	// Test: Later HTTP/1.x minor versions are accepted
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.2\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.2", r.RequestLine.HttpVersion)
+}
+
+func TestKeepAlive(t *testing.T) {
+	parse := func(raw string) *Request {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return r
+	}
+
+	assert.True(t, parse("GET / HTTP/1.1\r\nHost: a\r\n\r\n").KeepAlive())
+	assert.False(t, parse("GET / HTTP/1.1\r\nHost: a\r\nConnection: Close\r\n\r\n").KeepAlive())
+	assert.False(t, parse("GET / HTTP/1.0\r\n\r\n").KeepAlive())
+	assert.True(t, parse("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n").KeepAlive())
+	assert.False(t, parse("GET / HTTP/1.0\r\nConnection: keep-alive, close\r\n\r\n").KeepAlive())
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// Test: Bytes past the body are kept for the next request
+	r, err := RequestFromReader(strings.NewReader(
+		"POST /a HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET /b HTTP/1.1\r\n\r\n",
+	))
+	require.NoError(t, err)
+	assert.True(t, r.BodyRead())
+	assert.Equal(t, "abc", string(r.Body))
+	// Only what was read so far, the rest is still in the reader
+	assert.True(t, strings.HasPrefix("GET /b HTTP/1.1\r\n\r\n", string(r.Buffered())))
+	assert.NotEmpty(t, r.Buffered())
+
+	// Test: Leftover bytes survive the read buffer being reused
+	leftover := string(r.Buffered())
+	_, err = RequestFromReader(strings.NewReader("PUT /xxxxxxxxxxxxxxxxxxxxxxxx HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, leftover, string(r.Buffered()))
+
+	// Test: Request larger than the read buffer
+	longValue := strings.Repeat("v", 3*bufferSize)
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nX-Long: " + longValue + "\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, longValue, r.Headers["x-long"])
+
+	// Test: Header sections over the limit, in one field line or many
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("v", maxHeaderBytes) + "\r\n\r\n"))
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+	manyFields := strings.Repeat("X-Field: value\r\n", maxHeaderBytes/len("X-Field: value\r\n")+1)
+	_, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\n" + manyFields + "\r\n", numBytesPerRead: 1000})
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+	// A body doesn't count towards it.
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 100000\r\n\r\n" + strings.Repeat("b", 100000)))
+	require.NoError(t, err)
+	assert.Len(t, r.Body, 100000)
+
+	// Test: Nothing at all is plain EOF
+	_, err = RequestFromReader(strings.NewReader(""))
+	require.ErrorIs(t, err, io.EOF)
 }
 
 func TestHeaderParse(t *testing.T) {
@@ -217,3 +286,64 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+func TestDeferredBodyRead(t *testing.T) {
+	// Test: Headers are parsed without touching the body
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Expect: 100-continue\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.True(t, r.ExpectsContinue())
+	assert.Nil(t, r.Body)
+
+	hookCalls := 0
+	r.OnBodyRead(func() error {
+		hookCalls++
+		return nil
+	})
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, 1, hookCalls)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+
+	// Reading again is a no-op
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, 1, hookCalls)
+
+	// Test: Hook errors stop the body read
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+	r.OnBodyRead(func() error {
+		return io.ErrClosedPipe
+	})
+	require.ErrorIs(t, r.ReadBody(), io.ErrClosedPipe)
+
+	// Test: Hook is skipped when there is no body
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	r.OnBodyRead(func() error {
+		hookCalls++
+		return nil
+	})
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, 1, hookCalls)
+}
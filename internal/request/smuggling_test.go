@@ -0,0 +1,115 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"app/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireRejected parses raw strictly and checks it fails with target
+// (or with any error if target is nil).
+func requireRejected(t *testing.T, raw string, target error) {
+	t.Helper()
+	r, err := RequestFromReaderWithOptions(strings.NewReader(raw), ParseOptions{Strict: true})
+	require.Error(t, err)
+	require.Nil(t, r)
+	if target != nil {
+		require.ErrorIs(t, err, target)
+	}
+}
+
+func TestStrictParsing(t *testing.T) {
+	// Test: CL.CL with different values
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 5\r\n\r\nabcde", headers.ErrDuplicateField)
+
+	// Test: CL.CL with identical values
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc", headers.ErrDuplicateField)
+
+	// Test: CL list in a single field
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3, 3\r\n\r\nabc", ErrInvalidContentLength)
+
+	// Test: CL with a sign
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +3\r\n\r\nabc", ErrInvalidContentLength)
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n", ErrInvalidContentLength)
+
+	// Test: CL in hex or with junk
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x3\r\n\r\nabc", ErrInvalidContentLength)
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3 3\r\n\r\nabc", ErrInvalidContentLength)
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length:\r\n\r\n", ErrInvalidContentLength)
+
+	// Test: CL large enough to overflow
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999\r\n\r\n", ErrInvalidContentLength)
+
+	// Test: CL.TE
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nX", ErrTransferEncodingWithContentLength)
+
+	// Test: TE.CL
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n5c\r\nGPOST / HTTP/1.1\r\n\r\n0\r\n\r\n", ErrTransferEncodingWithContentLength)
+
+	// Test: TE alone, with obfuscated values
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", ErrUnsupportedTransferEncoding)
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n", ErrUnsupportedTransferEncoding)
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n", ErrUnsupportedTransferEncoding)
+
+	// Test: TE hidden behind obsolete line folding
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nX-Foo: bar\r\n Transfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\nabc", headers.ErrObsoleteLineFolding)
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\n\tTransfer-Encoding: chunked\r\n\r\n", headers.ErrObsoleteLineFolding)
+
+	// Test: Whitespace before the colon
+	requireRejected(t, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n", nil)
+
+	// Test: Control characters in field values
+	requireRejected(t, "GET / HTTP/1.1\r\nHost: a\r\nX-Foo: bar\x00baz\r\n\r\n", headers.ErrInvalidFieldValue)
+	requireRejected(t, "GET / HTTP/1.1\r\nHost: a\r\nX-Foo: bar\rContent-Length: 5\r\n\r\n", headers.ErrInvalidFieldValue)
+	requireRejected(t, "GET / HTTP/1.1\r\nHost: a\r\nX-Foo: bar\nContent-Length: 5\r\n\r\n", headers.ErrInvalidFieldValue)
+	requireRejected(t, "GET / HTTP/1.1\r\nHost: a\r\nX-Foo: bar\x0b\r\n\r\n", headers.ErrInvalidFieldValue)
+
+	// Test: Field line without a colon
+	requireRejected(t, "GET / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding\r\n\r\n", nil)
+
+	// Test: Whitespace tricks in the request-line
+	requireRejected(t, "GET  / HTTP/1.1\r\nHost: a\r\n\r\n", nil)
+	requireRejected(t, "GET\t/ HTTP/1.1\r\nHost: a\r\n\r\n", nil)
+	requireRejected(t, " GET / HTTP/1.1\r\nHost: a\r\n\r\n", nil)
+	requireRejected(t, "GET / HTTP/1.1 \r\nHost: a\r\n\r\n", nil)
+	requireRejected(t, "GET /a\x0bb HTTP/1.1\r\nHost: a\r\n\r\n", ErrInvalidRequestTarget)
+	requireRejected(t, "GET /\x7f HTTP/1.1\r\nHost: a\r\n\r\n", ErrInvalidRequestTarget)
+
+	// Test: Bare LF line endings
+	requireRejected(t, "GET / HTTP/1.1\nHost: a\r\n\r\n", nil)
+
+	// Test: Well-formed requests still parse
+	r, err := RequestFromReaderWithOptions(strings.NewReader(
+		"POST /submit HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nX-List: a, b\r\n\r\nabc",
+	), ParseOptions{Strict: true})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	assert.Equal(t, "a, b", r.Headers["x-list"])
+}
+
+func TestLenientContentLength(t *testing.T) {
+	// Test: Repeated identical Content-Length is collapsed
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+
+	// Test: Repeated differing Content-Length
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 3\r\nContent-Length: 5\r\n\r\nabcde"))
+	require.ErrorIs(t, err, ErrConflictingContentLength)
+
+	// Test: Negative Content-Length
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: -3\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidContentLength)
+
+	// Test: TE.CL is rejected without strict parsing too
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n1e\r\nGET /admin HTTP/1.1\r\nHost: a\r\n\r\n0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrTransferEncodingWithContentLength)
+
+	// Test: TE alone is rejected without strict parsing too
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedTransferEncoding)
+}
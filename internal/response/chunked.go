@@ -5,28 +5,130 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
+// ChunkExtension is a single chunk-ext name/value pair, written after
+// the chunk-size as `;name=value` (RFC 9112 section 7.1.1). An empty
+// Value writes the name alone.
+type ChunkExtension struct {
+	Name  string
+	Value string
+}
+
+// ChunkMode controls how WriteChunkedBodyFromReaderWithOptions turns
+// reads from the source into chunks.
+type ChunkMode int
+
+const (
+	// ChunkMax emits whatever a single Read returns, never more than
+	// ChunkOptions.Size bytes per chunk.
+	ChunkMax ChunkMode = iota
+	// ChunkFixed fills the buffer completely before emitting, so every
+	// chunk is exactly ChunkOptions.Size bytes except the last one.
+	ChunkFixed
+	// ChunkPassThrough keeps the boundaries of the source: every Write
+	// (if the reader implements io.WriterTo) or Read becomes one chunk.
+	ChunkPassThrough
+)
+
+const defaultChunkSize = 1024
+const passThroughBufferSize = 32 * 1024
+
+// ChunkOptions configures WriteChunkedBodyFromReaderWithOptions.
+type ChunkOptions struct {
+	Mode ChunkMode
+	// Size is the chunk size for ChunkMax and ChunkFixed, and the read
+	// buffer size for ChunkPassThrough. Defaults to 1024 (32KiB for
+	// ChunkPassThrough) when zero.
+	Size int
+	// Extensions, when set, is called for every chunk with its
+	// zero-based sequence number and data, and the returned extensions
+	// are attached to that chunk.
+	Extensions func(seq int, chunk []byte) []ChunkExtension
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	return w.WriteChunkedBodyWithExtensions(p)
+}
+
+// WriteChunkedBodyWithExtensions writes p as a single chunk with the
+// given chunk extensions attached to its chunk-size line.
+func (w *Writer) WriteChunkedBodyWithExtensions(p []byte, exts ...ChunkExtension) (int, error) {
 	if w.state != writingBody {
-		return 0, fmt.Errorf("Tried to write chunked body with invalid Writer state: %d", w.state)
+		return 0, w.stateError("write chunked body")
+	}
+
+	if w.pendingHeaders != nil {
+		return 0, fmt.Errorf("Compressed responses without chunked encoding must use WriteBody")
 	}
 
 	if len(p) == 0 {
 		return 0, nil
 	}
 
+	if w.compressor != nil {
+		return w.writeCompressedChunk(p, exts)
+	}
+
+	return w.writeChunk(p, exts)
+}
+
+// writeCompressedChunk feeds p through the compressor and flushes it, so
+// each chunk written by the caller comes out as one compressed chunk.
+func (w *Writer) writeCompressedChunk(p []byte, exts []ChunkExtension) (int, error) {
+	_, err := w.compressor.Write(p)
+	if err != nil {
+		return 0, fmt.Errorf("Error compressing chunk: %w", err)
+	}
+	err = w.compressor.Flush()
+	if err != nil {
+		return 0, fmt.Errorf("Error compressing chunk: %w", err)
+	}
+
+	n, err := w.writeChunk(w.compressBuf.Bytes(), exts)
+	w.compressBuf.Reset()
+	return n, err
+}
+
+func (w *Writer) writeChunk(p []byte, exts []ChunkExtension) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if w.discardBody {
+		w.discardedLength += len(p)
+		return len(p), nil
+	}
+
+	if w.closeDelimited {
+		n, err := w.writer.Write(p)
+		w.bodyBytes += int64(n)
+		return n, err
+	}
+
+	extText, err := formatChunkExtensions(exts)
+	if err != nil {
+		return 0, err
+	}
+
 	bytesWritten := 0
 
 	chunkSize := len(p)
-	n, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
+	n, err := fmt.Fprintf(w.writer, "%x%s\r\n", chunkSize, extText)
 	if err != nil {
 		return bytesWritten, fmt.Errorf("Error writing data-size hex: %w", err)
 	}
 	bytesWritten += n
 
-	p = append(p, '\r', '\n')
 	n, err = w.writer.Write(p)
+	w.bodyBytes += int64(n)
+	if err != nil {
+		return bytesWritten, fmt.Errorf("Error writing chunked body: %w", err)
+	}
+	bytesWritten += n
+
+	n, err = w.writer.Write([]byte{'\r', '\n'})
 	if err != nil {
 		return bytesWritten, fmt.Errorf("Error writing chunked body: %w", err)
 	}
@@ -37,25 +139,56 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.state != writingBody {
-		return 0, fmt.Errorf("Tried to end chunked body with invalid Writer state: %d", w.state)
+		return 0, w.stateError("end chunked body")
+	}
+
+	bytesWritten := 0
+	if w.compressor != nil {
+		err := w.compressor.Close()
+		w.compressor = nil
+		if err != nil {
+			return 0, fmt.Errorf("Error finishing compressed body: %w", err)
+		}
+		n, err := w.writeChunk(w.compressBuf.Bytes(), nil)
+		w.compressBuf.Reset()
+		bytesWritten += n
+		if err != nil {
+			return bytesWritten, err
+		}
+	}
+
+	if w.discardBody {
+		w.state = writingTrailers
+		return bytesWritten, w.writeHeadHeaders()
+	}
+
+	if w.closeDelimited {
+		w.state = writingTrailers
+		return bytesWritten, nil
 	}
 
 	n, err := w.writer.Write([]byte("0\r\n"))
+	bytesWritten += n
 
 	if err == nil {
 		w.state = writingTrailers
 	}
 
-	return n, err
+	return bytesWritten, err
 }
 
 func (w *Writer) WriteTrailers(trailers headers.Headers) error {
 	if w.state != writingTrailers {
-		return fmt.Errorf("Tried writing trailers with invalid Writer state: %d", w.state)
+		return w.stateError("write trailers")
 	}
 
-	for trailer, trailerVal := range trailers {
-		trailerLine := fmt.Sprintf("%s: %s\r\n", trailer, trailerVal)
+	if w.discardBody || w.closeDelimited {
+		w.state = writingDone
+		return nil
+	}
+
+	for _, trailer := range trailers.Fields() {
+		trailerLine := fmt.Sprintf("%s: %s\r\n", trailer.Name, trailer.Value)
 		err := w.write([]byte(trailerLine))
 		if err != nil {
 			return fmt.Errorf("Error writing trailer: %w", err)
@@ -72,26 +205,138 @@ func (w *Writer) WriteTrailers(trailers headers.Headers) error {
 }
 
 func (w *Writer) WriteChunkedBodyFromReader(r io.Reader) (int, error) {
-	bytesWritten := 0
+	return w.WriteChunkedBodyFromReaderWithOptions(r, ChunkOptions{})
+}
+
+// WriteChunkedBodyFromReaderWithOptions streams r as chunks sized
+// according to opts, then writes the last-chunk. It returns the number
+// of body bytes read from r, not counting chunk framing.
+func (w *Writer) WriteChunkedBodyFromReaderWithOptions(r io.Reader, opts ChunkOptions) (int, error) {
+	size := opts.Size
+	if size <= 0 {
+		size = defaultChunkSize
+		if opts.Mode == ChunkPassThrough {
+			size = passThroughBufferSize
+		}
+	}
 
-	readBuffer := make([]byte, 1024)
-	for !w.Done() {
-		n, err := r.Read(readBuffer)
+	cw := &chunkWriter{w: w, extensions: opts.Extensions}
+
+	var err error
+	switch opts.Mode {
+	case ChunkMax:
+		err = cw.copyFrom(r, size, false)
+	case ChunkFixed:
+		err = cw.copyFrom(r, size, true)
+	case ChunkPassThrough:
+		if wt, ok := r.(io.WriterTo); ok {
+			_, err = wt.WriteTo(cw)
+		} else {
+			err = cw.copyFrom(r, size, false)
+		}
+	default:
+		return 0, fmt.Errorf("Unknown chunk mode: %d", opts.Mode)
+	}
+	if err != nil {
+		return cw.bytesWritten, err
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	return cw.bytesWritten, err
+}
+
+// chunkWriter turns every Write into a single chunk, numbering the
+// chunks so the extension callback can tag them.
+type chunkWriter struct {
+	w            *Writer
+	extensions   func(seq int, chunk []byte) []ChunkExtension
+	seq          int
+	bytesWritten int
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var exts []ChunkExtension
+	if cw.extensions != nil {
+		exts = cw.extensions(cw.seq, p)
+	}
+
+	_, err := cw.w.WriteChunkedBodyWithExtensions(p, exts...)
+	if err != nil {
+		return 0, err
+	}
+	cw.seq++
+	cw.bytesWritten += len(p)
+
+	return len(p), nil
+}
+
+func (cw *chunkWriter) copyFrom(r io.Reader, size int, fill bool) error {
+	readBuffer := make([]byte, size)
+	for !cw.w.Done() {
+		var n int
+		var err error
+		if fill {
+			n, err = io.ReadFull(r, readBuffer)
+		} else {
+			n, err = r.Read(readBuffer)
+		}
 		if n > 0 {
-			_, err := w.WriteChunkedBody(readBuffer[0:n])
+			_, err := cw.Write(readBuffer[0:n])
 			if err != nil {
-				return bytesWritten, err
+				return err
 			}
-			bytesWritten += n
 		}
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
 			}
-			return bytesWritten, err
+			return err
 		}
 	}
+	return nil
+}
 
-	_, err := w.WriteChunkedBodyDone()
-	return bytesWritten, err
+// formatChunkExtensions renders exts as `;name=value` pairs, quoting
+// values that are not tokens.
+func formatChunkExtensions(exts []ChunkExtension) (string, error) {
+	if len(exts) == 0 {
+		return "", nil
+	}
+
+	var sb strings.Builder
+	for _, ext := range exts {
+		if !headers.IsToken(ext.Name) {
+			return "", fmt.Errorf("Invalid chunk extension name: %q", ext.Name)
+		}
+		sb.WriteByte(';')
+		sb.WriteString(ext.Name)
+
+		if ext.Value == "" {
+			continue
+		}
+		sb.WriteByte('=')
+		if headers.IsToken(ext.Value) {
+			sb.WriteString(ext.Value)
+			continue
+		}
+
+		sb.WriteByte('"')
+		for i := 0; i < len(ext.Value); i++ {
+			char := ext.Value[i]
+			if (char < ' ' && char != '\t') || char == 0x7f {
+				return "", fmt.Errorf("Invalid character in chunk extension value: %q", ext.Value)
+			}
+			if char == '"' || char == '\\' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(char)
+		}
+		sb.WriteByte('"')
+	}
+
+	return sb.String(), nil
 }
@@ -0,0 +1,99 @@
+package response
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBodyWriter(buf *bytes.Buffer) *Writer {
+	w := NewWriter(buf)
+	w.state = writingBody
+	return w
+}
+
+func TestChunkedBody(t *testing.T) {
+	// Test: Single chunk with extensions
+	buf := &bytes.Buffer{}
+	w := newBodyWriter(buf)
+	_, err := w.WriteChunkedBodyWithExtensions(
+		[]byte("hello"),
+		ChunkExtension{Name: "seq", Value: "1"},
+		ChunkExtension{Name: "note", Value: `say "hi"`},
+		ChunkExtension{Name: "last"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "5;seq=1;note=\"say \\\"hi\\\"\";last\r\nhello\r\n", buf.String())
+
+	// Test: Invalid extension name
+	buf = &bytes.Buffer{}
+	w = newBodyWriter(buf)
+	_, err = w.WriteChunkedBodyWithExtensions([]byte("hello"), ChunkExtension{Name: "bad name"})
+	require.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: Caller's buffer is not modified
+	buf = &bytes.Buffer{}
+	w = newBodyWriter(buf)
+	data := make([]byte, 3, 10)
+	copy(data, "abc")
+	_, err = w.WriteChunkedBody(data)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0}, data[3:5])
+
+	// Test: Max chunk size splits reads
+	buf = &bytes.Buffer{}
+	w = newBodyWriter(buf)
+	n, err := w.WriteChunkedBodyFromReaderWithOptions(
+		strings.NewReader("abcdefghij"),
+		ChunkOptions{Mode: ChunkMax, Size: 4},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, "4\r\nabcd\r\n4\r\nefgh\r\n2\r\nij\r\n0\r\n", buf.String())
+
+	// Test: Fixed chunk size fills across short reads
+	buf = &bytes.Buffer{}
+	w = newBodyWriter(buf)
+	n, err = w.WriteChunkedBodyFromReaderWithOptions(
+		iotest.OneByteReader(strings.NewReader("abcdefghij")),
+		ChunkOptions{Mode: ChunkFixed, Size: 4},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, "4\r\nabcd\r\n4\r\nefgh\r\n2\r\nij\r\n0\r\n", buf.String())
+
+	// Test: Pass-through keeps reader boundaries, with sequence numbers
+	buf = &bytes.Buffer{}
+	w = newBodyWriter(buf)
+	n, err = w.WriteChunkedBodyFromReaderWithOptions(
+		iotest.HalfReader(strings.NewReader("abcdefgh")),
+		ChunkOptions{
+			Mode: ChunkPassThrough,
+			Size: 2,
+			Extensions: func(seq int, _ []byte) []ChunkExtension {
+				return []ChunkExtension{{Name: "seq", Value: strconv.Itoa(seq)}}
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "1;seq=0\r\na\r\n1;seq=1\r\nb\r\n1;seq=2\r\nc\r\n1;seq=3\r\nd\r\n"+
+		"1;seq=4\r\ne\r\n1;seq=5\r\nf\r\n1;seq=6\r\ng\r\n1;seq=7\r\nh\r\n0\r\n", buf.String())
+
+	// Test: Pass-through uses WriteTo when available
+	buf = &bytes.Buffer{}
+	w = newBodyWriter(buf)
+	n, err = w.WriteChunkedBodyFromReaderWithOptions(
+		bytes.NewBufferString("abcdefghij"),
+		ChunkOptions{Mode: ChunkPassThrough, Size: 2},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, "a\r\nabcdefghij\r\n0\r\n", buf.String())
+}
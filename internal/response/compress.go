@@ -0,0 +1,240 @@
+package response
+
+import (
+	"app/internal/headers"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// compressor is implemented by both *gzip.Writer and *zlib.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Media types that are already compressed, so compressing them again
+// only costs CPU. Entries ending in "/" match the whole top-level type.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/x-bzip2",
+	"application/x-xz",
+}
+
+// Some image types are plain text and compress well.
+var compressibleExceptions = []string{"image/svg+xml"}
+
+// NegotiateEncoding picks the best content-coding this package supports
+// from an Accept-Encoding field value, honoring q-values (RFC 9110
+// section 12.5.3). It returns "" when the body should be sent as-is.
+func NegotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = EncodingGzip
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[coding] = q
+	}
+
+	best := ""
+	bestQ := 0.0
+	// Ordered by preference, so gzip wins ties.
+	for _, coding := range []string{EncodingGzip, EncodingDeflate} {
+		q, listed := qualities[coding]
+		if !listed {
+			q = wildcard
+		}
+		if q > bestQ {
+			best = coding
+			bestQ = q
+		}
+	}
+
+	return best
+}
+
+// IsCompressible reports whether a body with the given Content-Type is
+// worth compressing.
+func IsCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	for _, exception := range compressibleExceptions {
+		if mediaType == exception {
+			return true
+		}
+	}
+	for _, incompressible := range incompressibleTypes {
+		if strings.HasSuffix(incompressible, "/") && strings.HasPrefix(mediaType, incompressible) {
+			return false
+		}
+		if mediaType == incompressible {
+			return false
+		}
+	}
+	return true
+}
+
+// Compress asks the Writer to compress the body with the best coding
+// from the request's Accept-Encoding value. It must be called before
+// WriteHeaders, which decides from the response headers whether the
+// body is actually compressed.
+//
+// Content-Length responses are compressed as a whole in WriteBody, so
+// their headers are held back until then, or until Finish if the body
+// is never written. Empty ones aren't compressed at all. An ETag gets
+// the coding appended, see codingETag. Chunked responses are
+// compressed chunk by chunk, flushing the compressor after each one so
+// streaming still works.
+func (w *Writer) Compress(acceptEncoding string) error {
+	if w.state != writingStatusLine && w.state != writingHeaders {
+		return w.stateError("enable compression")
+	}
+	w.acceptEncoding = acceptEncoding
+	w.compressRequested = true
+	return nil
+}
+
+// prepareCompression updates h for compression and reports whether the
+// body will be compressed.
+func (w *Writer) prepareCompression(h headers.Headers) bool {
+	if !w.compressRequested || !bodyAllowed(w.status) {
+		return false
+	}
+	if w.status == StatusPartialContent {
+		// Byte ranges refer to the uncompressed representation.
+		return false
+	}
+	if _, encoded := h.Get("Content-Encoding"); encoded {
+		return false
+	}
+	contentType, _ := h.Get("Content-Type")
+	if !IsCompressible(contentType) {
+		return false
+	}
+
+	// The representation depends on Accept-Encoding even when we end up
+	// sending it uncompressed, so caches need to know.
+	addVary(h, "Accept-Encoding")
+
+	if contentLength, _ := h.Get("Content-Length"); contentLength == "0" && !isChunked(h) {
+		// Nothing to compress, and an empty body would only grow.
+		return false
+	}
+
+	w.encoding = NegotiateEncoding(w.acceptEncoding)
+	if w.encoding == "" {
+		return false
+	}
+
+	h.Replace("Content-Encoding", w.encoding)
+	codingETag(h, w.encoding)
+	return true
+}
+
+// codingETag gives the ETag in h a suffix for the content-coding, since
+// the compressed body is a different representation and must not share
+// a strong validator with the uncompressed one (RFC 9110 section
+// 8.8.3). Preconditions are checked against the changed tag, so a
+// client only gets 304 for the coding it has cached.
+func codingETag(h headers.Headers, coding string) {
+	etag, exists := h.Get("ETag")
+	if !exists {
+		return
+	}
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		// Not an entity-tag that can be extended, so it can't be kept.
+		h.Remove("ETag")
+		return
+	}
+	h.Replace("ETag", etag[:len(etag)-1]+"-"+coding+`"`)
+}
+
+func addVary(h headers.Headers, field string) {
+	vary, exists := h.Get("Vary")
+	if !exists {
+		h.Replace("Vary", field)
+		return
+	}
+	for _, existing := range strings.Split(vary, ",") {
+		existing = strings.TrimSpace(existing)
+		if existing == "*" || strings.EqualFold(existing, field) {
+			return
+		}
+	}
+	h.Set("Vary", field)
+}
+
+func newCompressor(encoding string, dst io.Writer) (compressor, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewWriter(dst), nil
+	case EncodingDeflate:
+		// HTTP's "deflate" is the zlib format (RFC 9110 section 8.4.1.2).
+		return zlib.NewWriter(dst), nil
+	default:
+		return nil, fmt.Errorf("Unsupported content-coding: %s", encoding)
+	}
+}
+
+// compressAll compresses a complete body in one go.
+func compressAll(encoding string, data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	c, err := newCompressor(encoding, buf)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,203 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "", NegotiateEncoding(""))
+	assert.Equal(t, "gzip", NegotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0.5, deflate"))
+	assert.Equal(t, "deflate", NegotiateEncoding("gzip;q=0, *"))
+	assert.Equal(t, "gzip", NegotiateEncoding("*;q=0.1"))
+	assert.Equal(t, "gzip", NegotiateEncoding("x-gzip"))
+	assert.Equal(t, "", NegotiateEncoding("identity, br"))
+	assert.Equal(t, "", NegotiateEncoding("gzip;q=0, deflate;q=0"))
+	assert.Equal(t, "", NegotiateEncoding("*;q=0"))
+}
+
+func TestIsCompressible(t *testing.T) {
+	assert.True(t, IsCompressible("text/html"))
+	assert.True(t, IsCompressible("application/json; charset=utf-8"))
+	assert.True(t, IsCompressible("image/svg+xml"))
+	assert.False(t, IsCompressible("video/mp4"))
+	assert.False(t, IsCompressible("image/png"))
+	assert.False(t, IsCompressible("Application/Zip"))
+}
+
+// readResponse splits a raw response into its headers and body.
+func readResponse(t *testing.T, raw []byte) (map[string]string, []byte) {
+	t.Helper()
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	fields := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		fields[name] = value
+	}
+
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return fields, body
+}
+
+// dechunk decodes a chunked body, ignoring extensions and trailers.
+func dechunk(t *testing.T, body []byte) []byte {
+	t.Helper()
+	reader := bufio.NewReader(bytes.NewReader(body))
+	out := []byte{}
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		sizeText, _, _ := strings.Cut(strings.TrimSuffix(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeText, 16, 64)
+		require.NoError(t, err)
+		if size == 0 {
+			return out
+		}
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(reader, chunk)
+		require.NoError(t, err)
+		out = append(out, chunk[:size]...)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	text := []byte(strings.Repeat("compress me please, ", 100))
+
+	// Test: Content-Length body with gzip
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.Compress("deflate;q=0.5, gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	n, err := w.WriteBody(text)
+	require.NoError(t, err)
+	assert.Equal(t, len(text), n)
+
+	fields, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, "gzip", fields["content-encoding"])
+	assert.Equal(t, "Accept-Encoding", fields["vary"])
+	assert.Equal(t, strconv.Itoa(len(body)), fields["content-length"])
+	assert.Less(t, len(body), len(text))
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, text, decoded)
+
+	// Test: Chunked body with deflate
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("deflate"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	chunkedHeaders := GetChunkedHeaders()
+	chunkedHeaders.Set("Content-Type", "application/json")
+	chunkedHeaders.Set("Vary", "Origin")
+	require.NoError(t, w.WriteHeaders(chunkedHeaders))
+	_, err = w.WriteChunkedBodyFromReaderWithOptions(bytes.NewReader(text), ChunkOptions{Size: 100})
+	require.NoError(t, err)
+
+	fields, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, "deflate", fields["content-encoding"])
+	assert.Equal(t, "Origin, Accept-Encoding", fields["vary"])
+	zr, err := zlib.NewReader(bytes.NewReader(dechunk(t, body)))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, text, decoded)
+
+	// Test: Already compressed media types are left alone
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	videoHeaders := GetDefaultHeaders(len(text))
+	videoHeaders.Replace("Content-Type", "video/mp4")
+	require.NoError(t, w.WriteHeaders(videoHeaders))
+	_, err = w.WriteBody(text)
+	require.NoError(t, err)
+
+	fields, body = readResponse(t, buf.Bytes())
+	assert.NotContains(t, fields, "content-encoding")
+	assert.Equal(t, text, body)
+
+	// Test: Client without Accept-Encoding still gets Vary
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress(""))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	_, err = w.WriteBody(text)
+	require.NoError(t, err)
+
+	fields, body = readResponse(t, buf.Bytes())
+	assert.NotContains(t, fields, "content-encoding")
+	assert.Equal(t, "Accept-Encoding", fields["vary"])
+	assert.Equal(t, text, body)
+
+	// Test: ETags get the content-coding as a suffix
+	for etag, expected := range map[string]string{`"v1"`: `"v1-gzip"`, `W/"v1"`: `W/"v1-gzip"`, `v1`: ""} {
+		buf = &bytes.Buffer{}
+		w = NewWriter(buf)
+		require.NoError(t, w.Compress("gzip"))
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		h := GetDefaultHeaders(len(text))
+		h.Set("ETag", etag)
+		require.NoError(t, w.WriteHeaders(h))
+		_, err = w.WriteBody(text)
+		require.NoError(t, err)
+
+		fields, _ = readResponse(t, buf.Bytes())
+		assert.Equal(t, expected, fields["etag"], etag)
+	}
+
+	// Test: Empty body without WriteBody is sent uncompressed right away
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	require.NoError(t, w.Finish())
+
+	fields, body = readResponse(t, buf.Bytes())
+	assert.NotContains(t, fields, "content-encoding")
+	assert.Equal(t, "0", fields["content-length"])
+	assert.Empty(t, body)
+
+	// Test: Finish completes a held back response whose body was never written
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.Done())
+
+	fields, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, "gzip", fields["content-encoding"])
+	assert.Equal(t, strconv.Itoa(len(body)), fields["content-length"])
+	gz, err = gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Empty(t, decoded)
+}
@@ -0,0 +1,54 @@
+package response
+
+import "app/internal/headers"
+
+// Fields a 304 response keeps from the response it replaces (RFC 9110
+// section 15.4.5).
+var notModifiedFields = []string{"cache-control", "content-location", "date", "etag", "expires", "vary"}
+
+// SetPreconditions has the Writer check the headers of a 2xx response
+// before sending them. check gets the headers, so it can compare the
+// request's conditional fields with their ETag and Last-Modified, and
+// returns 0 to send the response as it is, or a status such as 304 or
+// 412 to send instead. The replacement has no body, and whatever the
+// handler writes after its headers is dropped.
+//
+// The status-line is held back until WriteHeaders while a check is set.
+func (w *Writer) SetPreconditions(check func(h headers.Headers) StatusCode) error {
+	if w.state != writingStatusLine {
+		return w.stateError("set preconditions")
+	}
+	w.preconditions = check
+	return nil
+}
+
+// checkPreconditions sends the replacement response if the held back
+// one fails its preconditions, and reports whether it did.
+func (w *Writer) checkPreconditions(h headers.Headers) (bool, error) {
+	status := w.preconditions(h)
+	if status == 0 {
+		return false, w.writeStatusLine(w.status)
+	}
+
+	replacement := headers.Headers{}
+	if status == StatusNotModified {
+		for _, name := range notModifiedFields {
+			if value, exists := h.Get(name); exists {
+				replacement.Set(name, value)
+			}
+		}
+	} else {
+		replacement.Set("Content-Length", "0")
+	}
+	if connection, exists := h.Get("Connection"); exists {
+		replacement.Set("Connection", connection)
+	}
+
+	w.status = status
+	w.discardBody = true
+	err := w.writeStatusLine(status)
+	if err != nil {
+		return true, err
+	}
+	return true, w.writeHeaderLines(replacement)
+}
@@ -0,0 +1,108 @@
+package response
+
+import (
+	"app/internal/headers"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETags(t *testing.T) {
+	// Test: Formatting
+	assert.Equal(t, `"abc"`, StrongETag("abc"))
+	assert.Equal(t, `W/"abc"`, WeakETag("abc"))
+
+	// Test: The same content gets the same tag however it is hashed
+	tag := ETagFromBytes([]byte("content"), false)
+	fromReader, err := ETagFromReader(strings.NewReader("content"), false)
+	require.NoError(t, err)
+	assert.Equal(t, tag, fromReader)
+	assert.NotEqual(t, tag, ETagFromBytes([]byte("other"), false))
+	assert.Equal(t, "W/"+tag, ETagFromBytes([]byte("content"), true))
+
+	// Test: Hashing a body while it is streamed in chunks gives the tag
+	// of the whole content
+	content := strings.Repeat("streamed content ", 1000)
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetChunkedHeaders()))
+	hasher := sha256.New()
+	n, err := w.WriteChunkedBodyFromReader(io.TeeReader(strings.NewReader(content), hasher))
+	require.NoError(t, err)
+	assert.Equal(t, len(content), n)
+	assert.Equal(t, ETagFromBytes([]byte(content), true), ETagFromHash(hasher, true))
+
+	// Test: HTTP-dates in all three formats
+	expected := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{"Sun, 06 Nov 1994 08:49:37 GMT", "Sunday, 06-Nov-94 08:49:37 GMT", "Sun Nov  6 08:49:37 1994"} {
+		parsed, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, expected.Equal(parsed), value)
+	}
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(expected.In(time.FixedZone("EST", -5*3600))))
+	_, err = ParseTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestPreconditions(t *testing.T) {
+	body := []byte("conditional body")
+	respond := func(check func(h headers.Headers) StatusCode) (string, map[string]string, []byte) {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		require.NoError(t, w.SetPreconditions(check))
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		h := GetDefaultHeaders(len(body))
+		h.Set("ETag", `"v1"`)
+		h.Set("Cache-Control", "max-age=60")
+		h.Replace("Content-Type", "text/plain")
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody(body)
+		require.NoError(t, err)
+		assert.True(t, w.Done())
+		statusLine, _, _ := strings.Cut(buf.String(), "\r\n")
+		fields, rest := readResponse(t, buf.Bytes())
+		return statusLine, fields, rest
+	}
+
+	// Test: A passing check sends the response as it is
+	statusLine, fields, rest := respond(func(h headers.Headers) StatusCode {
+		etag, _ := h.Get("ETag")
+		assert.Equal(t, `"v1"`, etag)
+		return 0
+	})
+	assert.Equal(t, "HTTP/1.1 200 OK", statusLine)
+	assert.Equal(t, body, rest)
+
+	// Test: 304 keeps only the validator and caching fields
+	statusLine, fields, rest = respond(func(headers.Headers) StatusCode { return StatusNotModified })
+	assert.Equal(t, "HTTP/1.1 304 Not Modified", statusLine)
+	assert.Equal(t, map[string]string{"etag": `"v1"`, "cache-control": "max-age=60", "connection": "close"}, fields)
+	assert.Empty(t, rest)
+
+	// Test: 412 has an empty body
+	statusLine, fields, rest = respond(func(headers.Headers) StatusCode { return StatusPreconditionFailed })
+	assert.Equal(t, "HTTP/1.1 412 Precondition Failed", statusLine)
+	assert.Equal(t, "0", fields["content-length"])
+	assert.Empty(t, rest)
+
+	// Test: Other statuses aren't checked
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.SetPreconditions(func(headers.Headers) StatusCode {
+		t.Error("check called for 404")
+		return StatusNotModified
+	}))
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n", buf.String())
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+
+	// Test: Preconditions must be set before the status-line
+	assert.Error(t, w.SetPreconditions(func(headers.Headers) StatusCode { return 0 }))
+}
@@ -0,0 +1,99 @@
+package response
+
+import (
+	"app/internal/headers"
+	"fmt"
+	"strings"
+)
+
+// SetVersion sets the HTTP version of the response, which should match
+// the request: "1.0" or "1.1" (the default). HTTP/1.0 responses never
+// use chunked encoding and skip interim responses.
+func (w *Writer) SetVersion(version string) error {
+	if w.state != writingStatusLine {
+		return w.stateError("set version")
+	}
+	if version != "1.0" && version != "1.1" {
+		return fmt.Errorf("Unsupported response version: %s", version)
+	}
+	w.version = version
+	return nil
+}
+
+// Version returns the HTTP version the response is sent with, "1.0" or
+// "1.1".
+func (w *Writer) Version() string {
+	return w.version
+}
+
+// SetKeepAlive tells the Writer whether the client wants the connection
+// kept open after this response. When it doesn't, the response is sent
+// with Connection: close.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// Persistent reports whether the connection can be reused for another
+// request once this response is done: the body is delimited by its
+// length or chunked encoding, and neither side asked for it to close.
+func (w *Writer) Persistent() bool {
+	return w.Done() && !w.closeAfter
+}
+
+// SetDisconnectNotifier lets the Writer tell handlers when the client
+// goes away, see Disconnected. The server sets it for every response.
+func (w *Writer) SetDisconnectNotifier(notify func() <-chan struct{}) {
+	w.disconnected = notify
+}
+
+// Disconnected returns a channel that is closed once the client has
+// closed the connection, so long-running responses such as streams can
+// stop. The server can only tell after the request body has been read;
+// until then, and for Writers not made by the server, the channel is
+// nil and never closes.
+func (w *Writer) Disconnected() <-chan struct{} {
+	if w.disconnected == nil {
+		return nil
+	}
+	return w.disconnected()
+}
+
+// useCloseDelimited turns a chunked response into one whose body simply
+// ends when the connection closes, for clients that don't understand
+// chunked encoding. Trailers are dropped.
+func (w *Writer) useCloseDelimited(h headers.Headers) {
+	h.Remove("Transfer-Encoding")
+	h.Remove("Trailer")
+	w.closeDelimited = true
+}
+
+// setConnectionHeader decides whether the connection stays open after
+// this response and sets the Connection header to match.
+func (w *Writer) setConnectionHeader(h headers.Headers) {
+	connection, _ := h.Get("Connection")
+	closeRequested := false
+	for _, option := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(option), "close") {
+			closeRequested = true
+		}
+	}
+
+	_, hasLength := h.Get("Content-Length")
+	framed := hasLength || isChunked(h) || w.discardBody || !bodyAllowed(w.status)
+
+	if closeRequested || !w.keepAlive || !framed || w.closeDelimited {
+		h.Replace("Connection", "close")
+		w.closeAfter = true
+		return
+	}
+
+	if w.version == "1.0" {
+		h.Replace("Connection", "keep-alive")
+	}
+}
+
+// bodyAllowed reports whether a response with this status can carry a
+// body at all (RFC 9112 section 6.3).
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != 204 && statusCode != 304
+}
@@ -0,0 +1,69 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+// StrongETag formats opaque as a strong entity-tag, which promises the
+// representation is byte for byte the same whenever the tag is (RFC
+// 9110 section 8.8.3). opaque must not contain '"'.
+func StrongETag(opaque string) string {
+	return `"` + opaque + `"`
+}
+
+// WeakETag formats opaque as a weak entity-tag, which only promises the
+// representations are equivalent, e.g. the same content compressed
+// differently.
+func WeakETag(opaque string) string {
+	return `W/"` + opaque + `"`
+}
+
+// ETagFromHash makes an entity-tag from the sum of h, for callers that
+// hash the body as they produce it.
+func ETagFromHash(h hash.Hash, weak bool) string {
+	opaque := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+	if weak {
+		return WeakETag(opaque)
+	}
+	return StrongETag(opaque)
+}
+
+// ETagFromBytes makes an entity-tag from the SHA-256 of data.
+func ETagFromBytes(data []byte, weak bool) string {
+	h := sha256.New()
+	h.Write(data)
+	return ETagFromHash(h, weak)
+}
+
+// ETagFromReader makes an entity-tag from the SHA-256 of everything r
+// returns, without holding it in memory.
+func ETagFromReader(r io.Reader, weak bool) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", fmt.Errorf("Error hashing for ETag: %w", err)
+	}
+	return ETagFromHash(h, weak), nil
+}
+
+// FormatTime formats t as an HTTP-date, e.g. for Last-Modified.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseTime parses an HTTP-date in any of the three formats recipients
+// have to accept (RFC 9110 section 5.6.7).
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid HTTP-date: %q", value)
+}
@@ -0,0 +1,36 @@
+package response
+
+import "strconv"
+
+// DiscardBody makes the Writer answer a HEAD request: handlers run as
+// they would for GET, but body bytes are dropped. Content-Length
+// responses keep their Content-Length. Chunked responses are held back
+// until the body is done and then sent with the Content-Length the
+// body would have had instead of Transfer-Encoding.
+func (w *Writer) DiscardBody() error {
+	if w.state != writingStatusLine && w.state != writingHeaders {
+		return w.stateError("discard body")
+	}
+	w.discardBody = true
+	return nil
+}
+
+// writeHeadHeaders sends the headers held back for a chunked HEAD
+// response, now that the body length is known.
+func (w *Writer) writeHeadHeaders() error {
+	if w.headHeaders == nil {
+		return nil
+	}
+
+	h := w.headHeaders
+	w.headHeaders = nil
+	h.Remove("Transfer-Encoding")
+	h.Remove("Trailer")
+	h.Replace("Content-Length", strconv.Itoa(w.discardedLength))
+
+	err := w.writeHeaderLines(h)
+	// writeHeaderLines moves the state on to the body, which is already
+	// behind us.
+	w.state = writingTrailers
+	return err
+}
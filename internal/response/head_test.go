@@ -0,0 +1,74 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscardBody(t *testing.T) {
+	text := []byte(strings.Repeat("head ", 40))
+
+	// Test: Content-Length response keeps its length but drops the body
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.DiscardBody())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	n, err := w.WriteBody(text)
+	require.NoError(t, err)
+	assert.Equal(t, len(text), n)
+	assert.True(t, w.Done())
+
+	fields, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, "200", fields["content-length"])
+	assert.Empty(t, body)
+
+	// Test: Chunked response is sent with the total Content-Length
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.DiscardBody())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	chunkedHeaders := GetChunkedHeaders()
+	chunkedHeaders.Set("Trailer", "X-Content-Length")
+	require.NoError(t, w.WriteHeaders(chunkedHeaders))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+	_, err = w.WriteChunkedBodyFromReaderWithOptions(bytes.NewReader(text), ChunkOptions{Size: 64})
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(GetDefaultHeaders(0)))
+	assert.True(t, w.Done())
+
+	fields, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, "200", fields["content-length"])
+	assert.NotContains(t, fields, "transfer-encoding")
+	assert.NotContains(t, fields, "trailer")
+	assert.Empty(t, body)
+
+	// Test: Compressed Content-Length matches what GET would send
+	getBuf := &bytes.Buffer{}
+	w = NewWriter(getBuf)
+	w.Compress("gzip")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(len(text)))
+	w.WriteBody(text)
+	getFields, _ := readResponse(t, getBuf.Bytes())
+
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Compress("gzip")
+	require.NoError(t, w.DiscardBody())
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(GetDefaultHeaders(len(text)))
+	w.WriteBody(text)
+	fields, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, getFields["content-length"], fields["content-length"])
+	assert.Equal(t, "gzip", fields["content-encoding"])
+	assert.Empty(t, body)
+
+	// Test: Too late once headers are written
+	w = newBodyWriter(&bytes.Buffer{})
+	require.Error(t, w.DiscardBody())
+}
@@ -0,0 +1,57 @@
+package response
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrHijacked is returned by Writer methods once the connection has
+// been hijacked.
+var ErrHijacked = errors.New("Connection has been hijacked")
+
+// SetHijacker lets the Writer hand its connection over, see Hijack. The
+// server sets it for every response; hijack returns the connection and
+// the bytes already read from it past the request.
+func (w *Writer) SetHijacker(hijack func() (net.Conn, []byte, error)) {
+	w.hijacker = hijack
+}
+
+// Hijack takes the connection over from the server, for protocols that
+// replace HTTP on it: WebSocket after a 101 Switching Protocols sent
+// with WriteInterim, tunnels after a 2xx response to CONNECT, or custom
+// streaming. It returns the connection and the bytes the server had
+// already read from it past the request headers, which come before
+// anything still to be read from the connection. If the handler hasn't
+// read the request body (e.g. for a deferred Expect: 100-continue), the
+// start of it is among them, and req.ReadBody must not be called.
+//
+// Whatever was written before Hijack has been sent, so a handler can
+// write the status-line and headers itself first. Responses held back
+// to be finished later, for HEAD, compression or preconditions, can't
+// be hijacked. Afterwards the Writer returns ErrHijacked, the server
+// leaves the connection alone, including its deadlines, and the caller
+// has to close it.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.state == writingHijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijacker == nil {
+		return nil, nil, errors.New("Connection can't be hijacked")
+	}
+	if w.statusHeld || w.pendingHeaders != nil || w.headHeaders != nil {
+		return nil, nil, errors.New("Tried to hijack while the response is held back")
+	}
+
+	conn, buffered, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacker = nil
+	w.state = writingHijacked
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether Hijack has taken the connection over.
+func (w *Writer) Hijacked() bool {
+	return w.state == writingHijacked
+}
@@ -0,0 +1,62 @@
+package response
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	hijacker := func() (net.Conn, []byte, error) {
+		return server, []byte("buffered"), nil
+	}
+
+	// Test: Without a hijacker there is nothing to take over
+	_, _, err := NewWriter(&bytes.Buffer{}).Hijack()
+	assert.Error(t, err)
+
+	// Test: Hijacking hands over the connection and stops the Writer
+	w := NewWriter(server)
+	w.SetHijacker(hijacker)
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, "buffered", string(buffered))
+	assert.True(t, w.Hijacked())
+	assert.False(t, w.Done())
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrHijacked)
+	assert.ErrorIs(t, w.WriteInterim(StatusContinue, nil), ErrHijacked)
+	_, err = w.WriteBody([]byte("late"))
+	assert.ErrorIs(t, err, ErrHijacked)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+
+	// Test: A response can be started before hijacking
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetChunkedHeaders()))
+	_, _, err = w.Hijack()
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "HTTP/1.1 200 OK\r\n")
+	assert.ErrorIs(t, w.WriteTrailers(nil), ErrHijacked)
+
+	// Test: Held back responses can't be hijacked
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.DiscardBody())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetChunkedHeaders()))
+	_, _, err = w.Hijack()
+	assert.Error(t, err)
+	assert.False(t, w.Hijacked())
+}
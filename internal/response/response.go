@@ -7,9 +7,102 @@ import (
 
 type StatusCode int
 
+// TimeFormat is the layout of an HTTP-date (RFC 9110 section 5.6.7),
+// for fields such as Date and Last-Modified. Times must be in UTC.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+const StatusContinue StatusCode = 100
+const StatusSwitchingProtocols StatusCode = 101
+const StatusEarlyHints StatusCode = 103
 const StatusOK StatusCode = 200
+const StatusCreated StatusCode = 201
+const StatusAccepted StatusCode = 202
+const StatusNoContent StatusCode = 204
+const StatusPartialContent StatusCode = 206
+const StatusMovedPermanently StatusCode = 301
+const StatusFound StatusCode = 302
+const StatusSeeOther StatusCode = 303
+const StatusNotModified StatusCode = 304
+const StatusTemporaryRedirect StatusCode = 307
+const StatusPermanentRedirect StatusCode = 308
 const StatusBadRequest StatusCode = 400
+const StatusUnauthorized StatusCode = 401
+const StatusForbidden StatusCode = 403
+const StatusNotFound StatusCode = 404
+const StatusMethodNotAllowed StatusCode = 405
+const StatusProxyAuthRequired StatusCode = 407
+const StatusRequestTimeout StatusCode = 408
+const StatusConflict StatusCode = 409
+const StatusGone StatusCode = 410
+const StatusLengthRequired StatusCode = 411
+const StatusPreconditionFailed StatusCode = 412
+const StatusPayloadTooLarge StatusCode = 413
+const StatusURITooLong StatusCode = 414
+const StatusUnsupportedMediaType StatusCode = 415
+const StatusRangeNotSatisfiable StatusCode = 416
+const StatusExpectationFailed StatusCode = 417
+const StatusMisdirectedRequest StatusCode = 421
+const StatusUnprocessableContent StatusCode = 422
+const StatusUpgradeRequired StatusCode = 426
+const StatusTooManyRequests StatusCode = 429
+const StatusRequestHeaderFieldsTooLarge StatusCode = 431
 const StatusInternalError StatusCode = 500
+const StatusNotImplemented StatusCode = 501
+const StatusBadGateway StatusCode = 502
+const StatusServiceUnavailable StatusCode = 503
+const StatusGatewayTimeout StatusCode = 504
+const StatusHTTPVersionNotSupported StatusCode = 505
+
+// Reason phrases written in the status-line for each known code.
+var statusText = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusEarlyHints:                  "Early Hints",
+	StatusOK:                          "OK",
+	StatusCreated:                     "Created",
+	StatusAccepted:                    "Accepted",
+	StatusNoContent:                   "No Content",
+	StatusPartialContent:              "Partial Content",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusSeeOther:                    "See Other",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusProxyAuthRequired:           "Proxy Authentication Required",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusGone:                        "Gone",
+	StatusLengthRequired:              "Length Required",
+	StatusPreconditionFailed:          "Precondition Failed",
+	StatusPayloadTooLarge:             "Content Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusUnsupportedMediaType:        "Unsupported Media Type",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusExpectationFailed:           "Expectation Failed",
+	StatusMisdirectedRequest:          "Misdirected Request",
+	StatusUnprocessableContent:        "Unprocessable Content",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalError:               "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for a status code, or "" if the
+// code is unknown.
+func StatusText(statusCode StatusCode) string {
+	return statusText[statusCode]
+}
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	defaultHeaders := headers.Headers{}
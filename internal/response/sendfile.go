@@ -0,0 +1,33 @@
+package response
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// ReadFrom implements io.ReaderFrom by sending r as the body, the same
+// as WriteBodyFromReader.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	return w.WriteBodyFromReader(r)
+}
+
+// sendFileConn returns the TCP connection to copy r to with its
+// ReadFrom, which has the kernel send the file with sendfile or splice
+// instead of copying it through user space. That only works for the
+// raw bytes of a body framed by Content-Length, read straight from a
+// file or a part of one cut off with io.LimitReader.
+func (w *Writer) sendFileConn(r io.Reader) (*net.TCPConn, bool) {
+	if !w.sized || w.compressor != nil {
+		return nil, false
+	}
+	conn, ok := w.writer.(*net.TCPConn)
+	if !ok {
+		return nil, false
+	}
+	if limited, ok := r.(*io.LimitedReader); ok {
+		r = limited.R
+	}
+	_, ok = r.(*os.File)
+	return conn, ok
+}
@@ -0,0 +1,160 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(tb, err)
+	server := <-accepted
+	require.NotNil(tb, server)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server.(*net.TCPConn), client.(*net.TCPConn)
+}
+
+func tempFile(tb testing.TB, content []byte) *os.File {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "body")
+	require.NoError(tb, os.WriteFile(path, content, 0o644))
+	f, err := os.Open(path)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestSendFile(t *testing.T) {
+	text := strings.Repeat("sendfile body ", 10000)
+	f := tempFile(t, []byte(text))
+
+	send := func(body io.Reader, length int) (map[string]string, []byte) {
+		server, client := tcpPair(t)
+		received := make(chan []byte, 1)
+		go func() {
+			raw, _ := io.ReadAll(client)
+			received <- raw
+		}()
+
+		w := NewWriter(server)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(GetDefaultHeaders(length)))
+		_, ok := w.sendFileConn(body)
+		assert.True(t, ok)
+		n, err := w.ReadFrom(body)
+		require.NoError(t, err)
+		assert.Equal(t, int64(length), n)
+		assert.True(t, w.Done())
+		server.Close()
+		return readResponse(t, <-received)
+	}
+
+	// Test: Whole files go through the connection's ReadFrom
+	fields, body := send(f, len(text))
+	assert.Equal(t, strconv.Itoa(len(text)), fields["content-length"])
+	assert.Equal(t, text, string(body))
+
+	// Test: So do parts of files
+	_, err := f.Seek(14, io.SeekStart)
+	require.NoError(t, err)
+	_, body = send(io.LimitReader(f, 28), 28)
+	assert.Equal(t, text[14:42], string(body))
+
+	// Test: Other writers, readers and framings copy as before
+	server, _ := tcpPair(t)
+	w := NewWriter(server)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetChunkedHeaders()))
+	_, ok := w.sendFileConn(f)
+	assert.False(t, ok)
+
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	_, ok = w.sendFileConn(f)
+	assert.False(t, ok)
+
+	w = NewWriter(server)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	_, ok = w.sendFileConn(strings.NewReader(text))
+	assert.False(t, ok)
+}
+
+// BenchmarkFileBody compares sending a file as a Content-Length body,
+// which uses sendfile, with copying it through user space and with
+// sending it chunked.
+func BenchmarkFileBody(b *testing.B) {
+	const size = 8 << 20
+	f := tempFile(b, bytes.Repeat([]byte("x"), size))
+	server, client := tcpPair(b)
+	go io.Copy(io.Discard, client)
+
+	run := func(b *testing.B, send func(w *Writer) error) {
+		b.SetBytes(size)
+		for b.Loop() {
+			_, err := f.Seek(0, io.SeekStart)
+			require.NoError(b, err)
+			w := NewWriter(server)
+			require.NoError(b, w.WriteStatusLine(StatusOK))
+			require.NoError(b, send(w))
+		}
+	}
+
+	b.Run("sendfile", func(b *testing.B) {
+		run(b, func(w *Writer) error {
+			err := w.WriteHeaders(GetDefaultHeaders(size))
+			if err != nil {
+				return err
+			}
+			_, err = w.WriteBodyFromReader(f)
+			return err
+		})
+	})
+	b.Run("copy", func(b *testing.B) {
+		run(b, func(w *Writer) error {
+			err := w.WriteHeaders(GetDefaultHeaders(size))
+			if err != nil {
+				return err
+			}
+			_, err = w.WriteBodyFromReader(struct{ io.Reader }{f})
+			return err
+		})
+	})
+	b.Run("chunked", func(b *testing.B) {
+		run(b, func(w *Writer) error {
+			err := w.WriteHeaders(GetChunkedHeaders())
+			if err != nil {
+				return err
+			}
+			_, err = w.WriteChunkedBodyFromReader(f)
+			if err != nil {
+				return err
+			}
+			return w.WriteTrailers(nil)
+		})
+	})
+}
@@ -2,8 +2,12 @@ package response
 
 import (
 	"app/internal/headers"
+	"bytes"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 )
 
 type writerState int
@@ -14,15 +18,56 @@ const (
 	writingBody
 	writingTrailers
 	writingDone
+	// The connection was taken over, see hijack.go.
+	writingHijacked
 )
 
 type Writer struct {
 	writer io.Writer
 	state  writerState
+	status StatusCode
+	// Body bytes sent, see BodyBytes.
+	bodyBytes int64
+
+	// Connection handling, see connection.go
+	version        string
+	keepAlive      bool
+	closeAfter     bool
+	closeDelimited bool
+	sized          bool
+	disconnected   func() <-chan struct{}
+
+	// Compression, see compress.go
+	compressRequested bool
+	acceptEncoding    string
+	encoding          string
+	compressor        compressor
+	compressBuf       bytes.Buffer
+	pendingHeaders    headers.Headers
+
+	// HEAD responses, see head.go
+	discardBody     bool
+	headHeaders     headers.Headers
+	discardedLength int
+
+	// Conditional requests, see conditional.go
+	preconditions func(headers.Headers) StatusCode
+	statusHeld    bool
+
+	// Hijacking, see hijack.go
+	hijacker func() (net.Conn, []byte, error)
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{writer: w}
+	return &Writer{writer: w, version: "1.1", keepAlive: true}
+}
+
+// stateError is the error for calling a method in the wrong state.
+func (w *Writer) stateError(action string) error {
+	if w.state == writingHijacked {
+		return fmt.Errorf("Tried to %s: %w", action, ErrHijacked)
+	}
+	return fmt.Errorf("Tried to %s with invalid Writer state: %d", action, w.state)
 }
 
 func (w *Writer) write(p []byte) error {
@@ -32,40 +77,127 @@ func (w *Writer) write(p []byte) error {
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.state != writingStatusLine {
-		return fmt.Errorf("Tried to write status-line with invalid Writer state: %d", w.state)
+		return w.stateError("write status-line")
+	}
+
+	if statusCode < 200 {
+		return fmt.Errorf("Status code %d is interim, use WriteInterim", statusCode)
 	}
 
-	switch statusCode {
-	case StatusOK:
-		err := w.write([]byte("HTTP/1.1 200 OK\r\n"))
+	if w.preconditions != nil && statusCode < 300 {
+		// Sent by WriteHeaders once the preconditions are checked.
+		w.statusHeld = true
+	} else {
+		err := w.writeStatusLine(statusCode)
 		if err != nil {
 			return err
 		}
-	case StatusBadRequest:
-		err := w.write([]byte("HTTP/1.1 400 Bad Request\r\n"))
+	}
+
+	w.status = statusCode
+	w.state = writingHeaders
+	return nil
+}
+
+// writeStatusLine writes the status-line. Codes without a known reason
+// phrase, such as ones relayed from another server, are sent with an
+// empty reason, which RFC 9112 section 4 allows.
+func (w *Writer) writeStatusLine(statusCode StatusCode) error {
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("Invalid status code: %d", statusCode)
+	}
+	reason := statusText[statusCode]
+
+	return w.write(fmt.Appendf(nil, "HTTP/%s %d %s\r\n", w.version, statusCode, reason))
+}
+
+// WriteInterim sends a 1xx interim response, such as 100 Continue or
+// 103 Early Hints with Link headers, ahead of the final response. Any
+// number of interim responses may be sent before WriteStatusLine.
+func (w *Writer) WriteInterim(statusCode StatusCode, h headers.Headers) error {
+	if w.state != writingStatusLine {
+		return w.stateError("write interim response")
+	}
+	if statusCode < 100 || statusCode > 199 {
+		return fmt.Errorf("Status code %d is not an interim response", statusCode)
+	}
+	if w.version == "1.0" {
+		// HTTP/1.0 clients don't know about interim responses.
+		return nil
+	}
+
+	err := w.writeStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range h.Fields() {
+		err := w.write([]byte(field.Name + ": " + field.Value + "\r\n"))
 		if err != nil {
 			return err
 		}
-	case StatusInternalError:
-		err := w.write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
+	}
+
+	return w.write([]byte{'\r', '\n'})
+}
+
+func (w *Writer) WriteHeaders(headers headers.Headers) error {
+	if w.state != writingHeaders {
+		return w.stateError("write headers")
+	}
+
+	// Before the preconditions, which need the ETag of the coding sent.
+	compressed := w.prepareCompression(headers)
+
+	if w.statusHeld {
+		w.statusHeld = false
+		replaced, err := w.checkPreconditions(headers)
+		if replaced || err != nil {
+			return err
+		}
+	}
+
+	if compressed {
+		if !isChunked(headers) {
+			// The compressed length isn't known until WriteBody.
+			w.pendingHeaders = headers
+			w.state = writingBody
+			return nil
+		}
+		c, err := newCompressor(w.encoding, &w.compressBuf)
 		if err != nil {
 			return err
 		}
-	default:
-		return fmt.Errorf("Unknown status code: %d", statusCode)
+		w.compressor = c
 	}
 
-	w.state = writingHeaders
-	return nil
+	return w.startBody(headers)
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	if w.state != writingHeaders {
-		return fmt.Errorf("Tried to write headers with invalid Writer state: %d", w.state)
+// startBody sends the headers once compression is set up, or holds them
+// back for HEAD responses with chunked bodies.
+func (w *Writer) startBody(headers headers.Headers) error {
+	if w.discardBody && isChunked(headers) {
+		// Sent once the body is done and its length is known.
+		w.headHeaders = headers
+		w.state = writingBody
+		return nil
+	}
+
+	if w.version == "1.0" && isChunked(headers) {
+		w.useCloseDelimited(headers)
 	}
 
-	for name, value := range headers {
-		err := w.write([]byte(name + ": " + value + "\r\n"))
+	return w.writeHeaderLines(headers)
+}
+
+func (w *Writer) writeHeaderLines(headers headers.Headers) error {
+	w.setConnectionHeader(headers)
+	_, hasLength := headers.Get("Content-Length")
+	w.sized = hasLength && !isChunked(headers)
+
+	for _, field := range headers.Fields() {
+		err := w.write([]byte(field.Name + ": " + field.Value + "\r\n"))
 		if err != nil {
 			return err
 		}
@@ -82,10 +214,14 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 
 func (w *Writer) WriteBody(data []byte) (int, error) {
 	if w.state != writingBody {
-		return 0, fmt.Errorf("Tried to write body with invalid Writer state: %d", w.state)
+		return 0, w.stateError("write body")
 	}
 
-	n, err := w.writer.Write(data)
+	if w.pendingHeaders != nil {
+		return w.writeCompressedBody(data)
+	}
+
+	n, err := w.writeBodyBytes(data)
 	if err != nil {
 		return n, err
 	}
@@ -94,6 +230,152 @@ func (w *Writer) WriteBody(data []byte) (int, error) {
 	return n, nil
 }
 
+// Size of the buffer WriteBodyFromReader copies through.
+const copyBufferSize = 32 * 1024
+
+// WriteBodyFromReader streams the body from r until EOF, for responses
+// whose Content-Length was set in the headers, without holding it all
+// in memory like WriteBody. For responses to HEAD nothing is read from
+// r.
+//
+// If the body is being compressed, its length isn't known up front, so
+// the response is sent chunked instead. Files sent to a TCP connection
+// skip the copy through user space, see sendfile.go.
+func (w *Writer) WriteBodyFromReader(r io.Reader) (int64, error) {
+	if w.state != writingBody {
+		return 0, w.stateError("write body")
+	}
+
+	if w.pendingHeaders != nil {
+		return w.writeCompressedStream(r)
+	}
+
+	if w.discardBody {
+		w.state = writingDone
+		return 0, nil
+	}
+
+	var n int64
+	var err error
+	if conn, ok := w.sendFileConn(r); ok {
+		n, err = conn.ReadFrom(r)
+	} else {
+		// Only w.writer's Write method, so io.CopyBuffer doesn't hand
+		// the copy over to a ReadFrom on the connection.
+		dst := struct{ io.Writer }{w.writer}
+		n, err = io.CopyBuffer(dst, r, make([]byte, copyBufferSize))
+	}
+	w.bodyBytes += n
+	if err != nil {
+		return n, fmt.Errorf("Error writing body from reader: %w", err)
+	}
+
+	w.state = writingDone
+	return n, nil
+}
+
+// writeCompressedStream switches the held back headers over to chunked
+// encoding and streams r through the compressor.
+func (w *Writer) writeCompressedStream(r io.Reader) (int64, error) {
+	h := w.pendingHeaders
+	w.pendingHeaders = nil
+	h.Remove("Content-Length")
+	h.Replace("Transfer-Encoding", "chunked")
+
+	c, err := newCompressor(w.encoding, &w.compressBuf)
+	if err != nil {
+		return 0, err
+	}
+	w.compressor = c
+
+	err = w.startBody(h)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.WriteChunkedBodyFromReaderWithOptions(r, ChunkOptions{Size: copyBufferSize})
+	if err != nil {
+		return int64(n), err
+	}
+	return int64(n), w.WriteTrailers(nil)
+}
+
+// writeCompressedBody compresses data, fixes up Content-Length and
+// writes the held back headers followed by the body.
+func (w *Writer) writeCompressedBody(data []byte) (int, error) {
+	compressed, err := compressAll(w.encoding, data)
+	if err != nil {
+		return 0, fmt.Errorf("Error compressing body: %w", err)
+	}
+
+	pending := w.pendingHeaders
+	w.pendingHeaders = nil
+	if _, exists := pending.Get("Content-Length"); exists {
+		pending.Replace("Content-Length", strconv.Itoa(len(compressed)))
+	}
+	err = w.writeHeaderLines(pending)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.writeBodyBytes(compressed)
+	if err != nil {
+		return 0, err
+	}
+
+	w.state = writingDone
+	return len(data), nil
+}
+
+// writeBodyBytes writes message body bytes, which are dropped for
+// responses to HEAD requests.
+func (w *Writer) writeBodyBytes(p []byte) (int, error) {
+	if w.discardBody {
+		return len(p), nil
+	}
+	n, err := w.writer.Write(p)
+	w.bodyBytes += int64(n)
+	return n, err
+}
+
+func isChunked(h headers.Headers) bool {
+	te, _ := h.Get("Transfer-Encoding")
+	for _, coding := range strings.Split(te, ",") {
+		if strings.EqualFold(strings.TrimSpace(coding), "chunked") {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *Writer) Done() bool {
 	return w.state == writingDone
 }
+
+// Finish sends the headers of a response whose handler returned without
+// writing the body they were held back for: a compressed Content-Length
+// response gets an empty compressed body, and a chunked HEAD response a
+// Content-Length of 0. The server calls it after every handler.
+func (w *Writer) Finish() error {
+	if w.state != writingBody {
+		return nil
+	}
+	if w.pendingHeaders != nil {
+		_, err := w.writeCompressedBody(nil)
+		return err
+	}
+	return w.writeHeadHeaders()
+}
+
+// Status returns the status code of the final response, which may be a
+// 304 or 412 in place of the one written, or 0 before WriteStatusLine.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// BodyBytes returns the number of message body bytes sent so far, after
+// compression and without chunked framing. Bodies discarded for HEAD
+// count as 0.
+func (w *Writer) BodyBytes() int64 {
+	return w.bodyBytes
+}
@@ -0,0 +1,211 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"app/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteInterim(t *testing.T) {
+	// Test: Interim responses come before the final one
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteInterim(StatusContinue, nil))
+	hints := headers.Headers{}
+	hints.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInterim(StatusEarlyHints, hints))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t,
+		"HTTP/1.1 100 Continue\r\n\r\n"+
+			"HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\n",
+		buf.String(),
+	)
+
+	// Test: Interim response after the final status-line
+	require.Error(t, w.WriteInterim(StatusContinue, nil))
+
+	// Test: Final status codes are rejected as interim and vice versa
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteInterim(StatusOK, nil))
+	require.Error(t, w.WriteStatusLine(StatusContinue))
+}
+
+func TestConnectionHandling(t *testing.T) {
+	// Test: HTTP/1.0 status-line, keep-alive on request
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.SetVersion("1.0"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.Headers{}
+	h.Set("Content-Length", "2")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.True(t, w.Persistent())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.0 200 OK\r\n"))
+	fields, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, "keep-alive", fields["connection"])
+	assert.Equal(t, "ok", string(body))
+
+	// Test: HTTP/1.0 chunked response becomes close-delimited
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.SetVersion("1.0"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = headers.Headers{}
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Sum")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBodyWithExtensions([]byte("hello "), ChunkExtension{Name: "seq", Value: "0"})
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-sum": "1"}))
+	assert.False(t, w.Persistent())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nconnection: close\r\n\r\nhello world", buf.String())
+
+	// Test: Interim responses are skipped for HTTP/1.0
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.SetVersion("1.0"))
+	require.NoError(t, w.WriteInterim(StatusContinue, nil))
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: Client asked to close
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetKeepAlive(false)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h = headers.Headers{}
+	h.Set("Content-Length", "0")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody(nil)
+	require.NoError(t, err)
+	assert.False(t, w.Persistent())
+	assert.Contains(t, buf.String(), "connection: close\r\n")
+
+	// Test: Body without length framing forces close
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{}))
+	_, err = w.WriteBody([]byte("until close"))
+	require.NoError(t, err)
+	assert.False(t, w.Persistent())
+
+	// Test: Unsupported version
+	require.Error(t, NewWriter(&bytes.Buffer{}).SetVersion("2"))
+}
+
+func TestWriteBodyFromReader(t *testing.T) {
+	text := strings.Repeat("streamed body ", 5000)
+
+	// Test: Body is copied through with its Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	n, err := w.WriteBodyFromReader(strings.NewReader(text))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(text)), n)
+	assert.True(t, w.Done())
+	fields, body := readResponse(t, buf.Bytes())
+	assert.Equal(t, strconv.Itoa(len(text)), fields["content-length"])
+	assert.Equal(t, text, string(body))
+
+	// Test: Compressed bodies switch to chunked
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	_, err = w.WriteBodyFromReader(strings.NewReader(text))
+	require.NoError(t, err)
+	assert.True(t, w.Done())
+	fields, body = readResponse(t, buf.Bytes())
+	assert.NotContains(t, fields, "content-length")
+	assert.Equal(t, "chunked", fields["transfer-encoding"])
+	assert.Equal(t, "gzip", fields["content-encoding"])
+	gz, err := gzip.NewReader(bytes.NewReader(dechunk(t, body)))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(decoded))
+
+	// Test: Nothing is read for HEAD
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.DiscardBody())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(text))))
+	source := strings.NewReader(text)
+	_, err = w.WriteBodyFromReader(source)
+	require.NoError(t, err)
+	assert.Equal(t, len(text), source.Len())
+	fields, body = readResponse(t, buf.Bytes())
+	assert.Equal(t, strconv.Itoa(len(text)), fields["content-length"])
+	assert.Empty(t, body)
+
+	// Test: Partial content isn't compressed
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusPartialContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteBodyFromReader(strings.NewReader("hello"))
+	require.NoError(t, err)
+	fields, body = readResponse(t, buf.Bytes())
+	assert.NotContains(t, fields, "content-encoding")
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestStatusAndBodyBytes(t *testing.T) {
+	// Test: Plain body
+	w := NewWriter(&bytes.Buffer{})
+	assert.Equal(t, StatusCode(0), w.Status())
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotFound, w.Status())
+	assert.Equal(t, int64(5), w.BodyBytes())
+
+	// Test: Chunks are counted without their framing
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetChunkedHeaders()))
+	_, err = w.WriteChunkedBodyFromReader(strings.NewReader(strings.Repeat("x", 2500)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2500), w.BodyBytes())
+
+	// Test: Compressed bodies count what was sent
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Compress("gzip"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(1000)))
+	_, err = w.WriteBody(bytes.Repeat([]byte("a"), 1000))
+	require.NoError(t, err)
+	_, body, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	assert.Equal(t, int64(len(body)), w.BodyBytes())
+	assert.Less(t, w.BodyBytes(), int64(1000))
+
+	// Test: Nothing is sent for HEAD
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.DiscardBody())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), w.BodyBytes())
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFormat selects the line format AccessLog writes.
+type LogFormat int
+
+const (
+	// CommonLog is the Common Log Format:
+	//  host ident user [time] "request-line" status bytes
+	CommonLog LogFormat = iota
+	// CombinedLog is CommonLog followed by the quoted Referer and
+	// User-Agent.
+	CombinedLog
+	// JSONLog writes a JSON object per line, which also has the time the
+	// handler took.
+	JSONLog
+)
+
+// The time format of CommonLog and CombinedLog.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// ParseLogFormat returns the LogFormat called "common", "combined" or
+// "json".
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch strings.ToLower(name) {
+	case "common":
+		return CommonLog, nil
+	case "combined":
+		return CombinedLog, nil
+	case "json":
+		return JSONLog, nil
+	}
+	return 0, fmt.Errorf("Unknown log format: %q", name)
+}
+
+// accessLogMu is shared by every AccessLog and AccessLogRejects, so
+// lines they write to the same out don't interleave.
+var accessLogMu sync.Mutex
+
+// accessLogEntry is what AccessLog records about a request.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Target     string    `json:"target"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// AccessLog wraps next so that a line is written to out for every
+// request once it has been answered, in the given format. Lines are
+// written with a single Write each, one at a time across every
+// AccessLog and AccessLogRejects, so they can share out. Requests the
+// server rejects before any handler runs, such as malformed ones, don't
+// reach next and are logged by AccessLogRejects instead. The status is
+// 0 (- in CommonLog and CombinedLog) for connections hijacked without a
+// final response.
+func AccessLog(out io.Writer, format LogFormat, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+
+		entry := newAccessLogEntry(start, req.RemoteAddr, req)
+		entry.Status = int(w.Status())
+		entry.Bytes = w.BodyBytes()
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+
+		writeAccessLog(out, formatAccessLog(entry, format))
+	}
+}
+
+// AccessLogRejects returns an option that writes a line to out, in the
+// same way as AccessLog, for every request the server answers with an
+// error before any handler runs, see WithRejectHook. The request-line
+// is - (empty in JSONLog) for requests that couldn't be parsed.
+func AccessLogRejects(out io.Writer, format LogFormat) Option {
+	return WithRejectHook(func(r RejectedRequest) {
+		entry := newAccessLogEntry(r.Time, r.RemoteAddr, r.Request)
+		entry.Status = int(r.StatusCode)
+		entry.Bytes = r.BodyBytes
+
+		writeAccessLog(out, formatAccessLog(entry, format))
+	})
+}
+
+func writeAccessLog(out io.Writer, line []byte) {
+	accessLogMu.Lock()
+	defer accessLogMu.Unlock()
+	out.Write(line)
+}
+
+// newAccessLogEntry fills in what is known about a request before it is
+// answered. req may be nil.
+func newAccessLogEntry(start time.Time, remoteAddr string, req *request.Request) accessLogEntry {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	entry := accessLogEntry{Time: start, RemoteAddr: host}
+	if req == nil {
+		return entry
+	}
+
+	entry.Method = req.RequestLine.Method
+	entry.Target = req.RequestLine.RequestTarget
+	entry.Protocol = "HTTP/" + req.RequestLine.HttpVersion
+	entry.Referer, _ = req.Headers.Get("Referer")
+	entry.UserAgent, _ = req.Headers.Get("User-Agent")
+	return entry
+}
+
+func formatAccessLog(entry accessLogEntry, format LogFormat) []byte {
+	if format == JSONLog {
+		line, _ := json.Marshal(entry)
+		return append(line, '\n')
+	}
+
+	status := "-"
+	if entry.Status != 0 {
+		status = strconv.Itoa(entry.Status)
+	}
+	size := "-"
+	if entry.Bytes != 0 {
+		size = strconv.FormatInt(entry.Bytes, 10)
+	}
+	requestLine := "-"
+	if entry.Method != "" {
+		requestLine = entry.Method + " " + entry.Target + " " + entry.Protocol
+	}
+	line := fmt.Appendf(nil, "%s - - [%s] \"%s\" %s %s",
+		entry.RemoteAddr, entry.Time.Format(clfTimeFormat), escapeLogField(requestLine), status, size)
+	if format == CombinedLog {
+		line = fmt.Appendf(line, " \"%s\" \"%s\"", escapeLogField(orDash(entry.Referer)), escapeLogField(orDash(entry.UserAgent)))
+	}
+	return append(line, '\n')
+}
+
+// escapeLogField escapes quotes, backslashes and bytes outside printable
+// ASCII the way Apache does, so a client can't break up or forge lines.
+func escapeLogField(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		char := s[i]
+		switch {
+		case char == '"' || char == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(char)
+		case char < ' ' || char > '~':
+			fmt.Fprintf(&sb, "\\x%02x", char)
+		default:
+			sb.WriteByte(char)
+		}
+	}
+	return sb.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// LogFile is an append-only file for AccessLog that can be reopened,
+// so that log rotation can move it away and have a new one created at
+// the same path.
+type LogFile struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenLogFile opens path for appending, creating it if needed.
+func OpenLogFile(path string) (*LogFile, error) {
+	f := &LogFile{path: path}
+	err := f.Reopen()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reopen closes the file and opens path again. If opening fails, the
+// old file is kept.
+func (f *LogFile) Reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("Error opening log file: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = file
+	return nil
+}
+
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(p)
+}
+
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logBuffer collects what AccessLog writes.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// next waits for the line about the request before.
+func (b *logBuffer) next(t *testing.T) string {
+	t.Helper()
+	for range 100 {
+		b.mu.Lock()
+		line, err := b.buf.ReadString('\n')
+		if err != nil {
+			// Not complete yet, put it back.
+			rest := b.buf.String()
+			b.buf.Reset()
+			b.buf.WriteString(line + rest)
+		}
+		b.mu.Unlock()
+		if err == nil {
+			return line
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no access log line")
+	return ""
+}
+
+func TestAccessLog(t *testing.T) {
+	out := &logBuffer{}
+	format := CombinedLog
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		AccessLog(out, format, echoHandler)(w, req)
+	})
+
+	// Test: Combined Log Format
+	roundTrip(t, s, "POST /echo?x=1 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nReferer: http://localhost/\r\nUser-Agent: test \"agent\" é\r\n\r\nhello")
+	line := out.next(t)
+	pattern := `^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /echo\?x=1 HTTP/1\.1" 200 5 "http://localhost/" "test \\"agent\\" \\xc3\\xa9"\n$`
+	assert.Regexp(t, regexp.MustCompile(pattern), line)
+
+	// Test: Common Log Format, with - for an empty body
+	format = CommonLog
+	roundTrip(t, s, "HEAD /echo HTTP/1.0\r\n\r\n")
+	line = out.next(t)
+	assert.True(t, strings.HasSuffix(line, `] "HEAD /echo HTTP/1.0" 200 -`+"\n"), line)
+
+	// Test: JSON lines
+	format = JSONLog
+	roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out.next(t)), &entry))
+	assert.Equal(t, "127.0.0.1", entry["remote_addr"])
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/", entry["target"])
+	assert.Equal(t, "HTTP/1.1", entry["protocol"])
+	assert.Equal(t, float64(200), entry["status"])
+	assert.Equal(t, float64(2), entry["bytes"])
+	assert.Contains(t, entry, "duration_ms")
+	assert.Contains(t, entry, "time")
+	assert.NotContains(t, entry, "referer")
+}
+
+func TestAccessLogRejects(t *testing.T) {
+	out := &logBuffer{}
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		AccessLog(out, CommonLog, echoHandler)(w, req)
+	}, AccessLogRejects(out, CommonLog))
+
+	// Test: A request that can't be parsed is logged without a request-line
+	resp := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n")
+	require.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	line := out.next(t)
+	assert.Regexp(t, regexp.MustCompile(`^127\.0\.0\.1 - - \[[^]]+\] "-" 400 \d+\n$`), line)
+
+	// Test: A request rejected after parsing keeps its request-line
+	roundTrip(t, s, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	line = out.next(t)
+	assert.Regexp(t, regexp.MustCompile(`\] "BREW /pot HTTP/1\.1" 501 \d+\n$`), line)
+}
+
+// overlapWriter notes whether Write was ever called while another Write
+// was still running.
+type overlapWriter struct {
+	active  atomic.Int32
+	overlap atomic.Bool
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if w.active.Add(1) > 1 {
+		w.overlap.Store(true)
+	}
+	time.Sleep(time.Millisecond)
+	w.active.Add(-1)
+	return len(p), nil
+}
+
+func TestAccessLogSharedWriter(t *testing.T) {
+	out := &overlapWriter{}
+	logged := AccessLog(out, CommonLog, func(w *response.Writer, req *request.Request) {})
+	s := &Server{}
+	AccessLogRejects(out, CommonLog)(s)
+	reject := s.rejectHooks[0]
+
+	// Test: AccessLog and AccessLogRejects don't write to the same out at
+	// the same time
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			req := &request.Request{RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}, RemoteAddr: "127.0.0.1:1"}
+			logged(response.NewWriter(io.Discard), req)
+		}()
+		go func() {
+			defer wg.Done()
+			reject(RejectedRequest{Time: time.Now(), RemoteAddr: "127.0.0.1:1", StatusCode: response.StatusBadRequest})
+		}()
+	}
+	wg.Wait()
+	assert.False(t, out.overlap.Load())
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for name, expected := range map[string]LogFormat{"common": CommonLog, "Combined": CombinedLog, "json": JSONLog} {
+		format, err := ParseLogFormat(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, format)
+	}
+	_, err := ParseLogFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenLogFile(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Test: After the file is moved away, reopening starts a new one
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(path, path+".1"))
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Reopen())
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(rotated))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "third\n", string(current))
+
+	// Test: A failed reopen keeps the old file
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.Mkdir(path, 0o755))
+	assert.Error(t, f.Reopen())
+	_, err = f.Write([]byte("fourth\n"))
+	assert.NoError(t, err)
+}
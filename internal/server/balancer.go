@@ -0,0 +1,408 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/headers"
+	"app/internal/request"
+	"app/internal/response"
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy decides which upstream a LoadBalancer sends each
+// request to.
+type BalanceStrategy int
+
+const (
+	// RoundRobin takes the upstreams in turn.
+	RoundRobin BalanceStrategy = iota
+	// LeastConnections picks the upstream with the fewest requests in
+	// flight.
+	LeastConnections
+	// ConsistentHash sends requests with the same value of
+	// ProxyOptions.HashHeader (or from the same client IP when it is
+	// empty or missing) to the same upstream, and moves as few of them
+	// as possible when upstreams come and go.
+	ConsistentHash
+)
+
+const (
+	defaultMaxAttempts         = 3
+	defaultMaxFails            = 3
+	defaultFailTimeout         = 30 * time.Second
+	defaultResponseTimeout     = 30 * time.Second
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	// Points each upstream gets on the consistent hash ring.
+	hashReplicas = 100
+)
+
+// ProxyOptions configures ReverseProxyWithOptions and NewLoadBalancer.
+type ProxyOptions struct {
+	// StripPrefix is removed from the start of the request target
+	// before it is appended to the upstream path.
+	StripPrefix string
+	// Client sends the upstream requests. Each upstream gets its own
+	// Client when nil, using TLS for https upstreams.
+	Client *client.Client
+	// ResponseHeaderTimeout is how long the Clients made for each
+	// upstream wait for a response head, after which the upstream counts
+	// as failed. Defaults to 30s when zero. A Client given in Client
+	// keeps its own setting.
+	ResponseHeaderTimeout time.Duration
+
+	Strategy BalanceStrategy
+	// HashHeader is the field ConsistentHash hashes on.
+	HashHeader string
+
+	// MaxAttempts is how many upstreams a request with an idempotent
+	// method is tried on when they can't be reached. Defaults to 3 when
+	// zero. Other requests are only ever tried once.
+	MaxAttempts int
+	// An upstream that fails MaxFails requests in a row is skipped for
+	// FailTimeout. Default to 3 and 30s when zero.
+	MaxFails    int
+	FailTimeout time.Duration
+
+	HealthCheck HealthCheck
+
+	// ModifyResponse, if set, is called with each upstream response
+	// before it is relayed, and may change its headers, replace its
+	// body or add trailers. Trailers are sent once the body has been
+	// read to EOF, so a body can fill them in as it is read.
+	ModifyResponse func(req *request.Request, resp *client.Response)
+
+	// Logger gets the events of the load balancer that don't belong to a
+	// request, such as health check changes. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// HealthCheck configures active health checks: every Interval each
+// upstream gets a GET for Path, and is skipped until it answers with a
+// 2xx or 3xx status again. No checks are made when Path is empty.
+type HealthCheck struct {
+	Path string
+	// Default to 10s and 2s when zero.
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// LoadBalancer is a reverse proxy that spreads requests over several
+// upstreams, see ReverseProxyWithOptions for how requests are relayed.
+// When every upstream is down, requests are sent to them anyway rather
+// than failed outright.
+type LoadBalancer struct {
+	backends []*backend
+	opts     ProxyOptions
+	next     atomic.Uint64
+	ring     []ringPoint
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// backend is an upstream with the state the LoadBalancer tracks for it.
+type backend struct {
+	*upstream
+	active    atomic.Int64
+	unhealthy atomic.Bool
+
+	mu           sync.Mutex
+	fails        int
+	ejectedUntil time.Time
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *backend
+}
+
+// NewLoadBalancer returns a LoadBalancer for the upstream URLs. It
+// starts active health checks when opts.HealthCheck.Path is set, which
+// run until Close.
+func NewLoadBalancer(upstreams []string, opts ProxyOptions) (*LoadBalancer, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("No upstreams given")
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.MaxFails == 0 {
+		opts.MaxFails = defaultMaxFails
+	}
+	if opts.FailTimeout == 0 {
+		opts.FailTimeout = defaultFailTimeout
+	}
+	if opts.ResponseHeaderTimeout == 0 {
+		opts.ResponseHeaderTimeout = defaultResponseTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	lb := &LoadBalancer{opts: opts, stop: make(chan struct{})}
+	for _, rawURL := range upstreams {
+		u, err := newUpstream(rawURL, opts.Client, opts.ResponseHeaderTimeout)
+		if err != nil {
+			return nil, err
+		}
+		lb.backends = append(lb.backends, &backend{upstream: u})
+	}
+
+	if opts.Strategy == ConsistentHash {
+		lb.buildRing()
+	}
+	if opts.HealthCheck.Path != "" {
+		go lb.checkHealth()
+	}
+
+	return lb, nil
+}
+
+// Handler returns the Handler that proxies requests.
+func (lb *LoadBalancer) Handler() Handler {
+	return lb.serve
+}
+
+// Close stops the health checks.
+func (lb *LoadBalancer) Close() {
+	lb.stopOnce.Do(func() { close(lb.stop) })
+}
+
+func (lb *LoadBalancer) serve(w *response.Writer, req *request.Request) {
+	err := req.ReadBody()
+	if err != nil {
+		writeError(w, response.StatusBadRequest, err)
+		return
+	}
+
+	attempts := 1
+	if method, known := request.LookupMethod(req.RequestLine.Method); known && method.Idempotent {
+		attempts = lb.opts.MaxAttempts
+	}
+
+	tried := map[*backend]bool{}
+	for range attempts {
+		b := lb.pick(req, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		b.active.Add(1)
+		resp, err := b.client.Do(b.address, b.outgoingRequest(req, lb.opts.StripPrefix))
+		if err != nil {
+			b.active.Add(-1)
+			req.Log().Warn("Error proxying", "upstream", b.address, "err", err)
+			lb.markFailed(b)
+			continue
+		}
+		lb.markSucceeded(b)
+
+		if lb.opts.ModifyResponse != nil {
+			lb.opts.ModifyResponse(req, resp)
+		}
+		relayResponse(w, req, resp)
+		resp.Body.Close()
+		b.active.Add(-1)
+		return
+	}
+
+	writeError(w, response.StatusBadGateway, errors.New("Upstream server unavailable"))
+}
+
+// pick chooses a backend that hasn't been tried yet for this request,
+// preferring ones that are up. It returns nil once all were tried.
+func (lb *LoadBalancer) pick(req *request.Request, tried map[*backend]bool) *backend {
+	now := time.Now()
+	var candidates []*backend
+	for _, b := range lb.backends {
+		if !tried[b] && b.available(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, b := range lb.backends {
+			if !tried[b] {
+				candidates = append(candidates, b)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch lb.opts.Strategy {
+	case LeastConnections:
+		// Rotate the starting point so ties are shared out.
+		start := int(lb.next.Add(1)-1) % len(candidates)
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			b := candidates[(start+i)%len(candidates)]
+			if b.active.Load() < best.active.Load() {
+				best = b
+			}
+		}
+		return best
+	case ConsistentHash:
+		return lb.lookupRing(hashKey(req, lb.opts.HashHeader), candidates)
+	default:
+		return candidates[int(lb.next.Add(1)-1)%len(candidates)]
+	}
+}
+
+func (b *backend) available(now time.Time) bool {
+	if b.unhealthy.Load() {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.ejectedUntil)
+}
+
+// markFailed counts a failed request against b, ejecting it once it has
+// failed MaxFails times in a row.
+func (lb *LoadBalancer) markFailed(b *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.fails++
+	if b.fails >= lb.opts.MaxFails {
+		lb.opts.Logger.Warn("Ejecting upstream", "upstream", b.address, "for", lb.opts.FailTimeout, "failures", b.fails)
+		b.ejectedUntil = time.Now().Add(lb.opts.FailTimeout)
+		b.fails = 0
+	}
+}
+
+func (lb *LoadBalancer) markSucceeded(b *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fails = 0
+}
+
+func (lb *LoadBalancer) buildRing() {
+	for _, b := range lb.backends {
+		for i := range hashReplicas {
+			lb.ring = append(lb.ring, ringPoint{hash: hash64(fmt.Sprintf("%s#%d", b.address, i)), backend: b})
+		}
+	}
+	slices.SortFunc(lb.ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+}
+
+// lookupRing walks the ring clockwise from key to the first point that
+// belongs to one of candidates.
+func (lb *LoadBalancer) lookupRing(key uint64, candidates []*backend) *backend {
+	start, _ := slices.BinarySearchFunc(lb.ring, key, func(p ringPoint, key uint64) int {
+		return cmp.Compare(p.hash, key)
+	})
+	for i := range lb.ring {
+		point := lb.ring[(start+i)%len(lb.ring)]
+		if slices.Contains(candidates, point.backend) {
+			return point.backend
+		}
+	}
+	return candidates[0]
+}
+
+// hashKey hashes the value of field, or the client IP when there is no
+// field or the request doesn't have it.
+func hashKey(req *request.Request, field string) uint64 {
+	value, exists := req.Headers.Get(field)
+	if field == "" || !exists {
+		value, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+	return hash64(value)
+}
+
+// hash64 is FNV-1a followed by the murmur3 finalizer, since FNV alone
+// leaves short keys that differ in a single character close together
+// on the ring.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// checkHealth checks every upstream right away and then once per
+// interval until Close.
+func (lb *LoadBalancer) checkHealth() {
+	interval := lb.opts.HealthCheck.Interval
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, b := range lb.backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lb.checkBackend(b)
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-lb.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lb *LoadBalancer) checkBackend(b *backend) {
+	timeout := lb.opts.HealthCheck.Timeout
+	if timeout == 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	// A separate client whose connections give up after timeout, and
+	// aren't pooled since the checks ask for them to be closed.
+	checker := &client.Client{
+		TLSConfig: b.client.TLSConfig,
+		Dial: func(address string) (net.Conn, error) {
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetDeadline(time.Now().Add(timeout))
+			return conn, nil
+		},
+	}
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: b.target(lb.opts.HealthCheck.Path, ""), HttpVersion: "1.1"},
+		Headers:     headers.Headers{},
+	}
+	req.Headers.Set("Host", b.url.Host)
+	req.Headers.Set("Connection", "close")
+
+	healthy := false
+	resp, err := checker.Do(b.address, req)
+	if err == nil {
+		_, err = resp.ReadBody()
+		healthy = err == nil && resp.StatusLine.StatusCode < 400
+	}
+
+	wasUnhealthy := b.unhealthy.Swap(!healthy)
+	if wasUnhealthy == healthy {
+		lb.opts.Logger.Info("Upstream health changed", "upstream", b.address, "healthy", healthy)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/request"
+	"app/internal/response"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedBackend starts an upstream that answers every request with its
+// name, and returns its URL.
+func namedBackend(t *testing.T, name string) string {
+	t.Helper()
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(name)
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(len(body))
+		h.Remove("Connection")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	})
+	return "http://" + s.Addr().String()
+}
+
+// deadBackend returns the URL of an address nothing listens on.
+func deadBackend(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + addr
+}
+
+func startBalancer(t *testing.T, upstreams []string, opts ProxyOptions) (*LoadBalancer, string) {
+	t.Helper()
+	lb, err := NewLoadBalancer(upstreams, opts)
+	require.NoError(t, err)
+	t.Cleanup(lb.Close)
+	s := startServer(t, lb.Handler())
+	return lb, s.Addr().String()
+}
+
+// fetch sends a request through the balancer and returns the status
+// and body.
+func fetch(t *testing.T, c *client.Client, addr string, req *request.Request) (response.StatusCode, string) {
+	t.Helper()
+	resp, err := c.Do(addr, req)
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	return resp.StatusLine.StatusCode, string(body)
+}
+
+func TestRoundRobin(t *testing.T) {
+	upstreams := []string{namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "c")}
+	_, addr := startBalancer(t, upstreams, ProxyOptions{})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Upstreams are taken in turn
+	var got []string
+	for range 6 {
+		_, body := fetch(t, c, addr, proxyRequest("GET", "/", nil))
+		got = append(got, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	slow := startServer(t, func(w *response.Writer, req *request.Request) {
+		<-release
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(4))
+		w.WriteBody([]byte("slow"))
+	})
+	upstreams := []string{"http://" + slow.Addr().String(), namedBackend(t, "fast")}
+	lb, addr := startBalancer(t, upstreams, ProxyOptions{Strategy: LeastConnections})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// With no requests in flight, the first goes to the first upstream.
+	slowDone := make(chan string)
+	go func() {
+		_, body := fetch(t, &client.Client{}, addr, proxyRequest("GET", "/", nil))
+		slowDone <- body
+	}()
+	require.Eventually(t, func() bool {
+		return lb.backends[0].active.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// Test: While one upstream is busy, requests go to the idle one
+	for range 4 {
+		_, body := fetch(t, c, addr, proxyRequest("GET", "/", nil))
+		assert.Equal(t, "fast", body)
+	}
+
+	close(release)
+	assert.Equal(t, "slow", <-slowDone)
+}
+
+func TestConsistentHash(t *testing.T) {
+	upstreams := []string{namedBackend(t, "a"), namedBackend(t, "b"), namedBackend(t, "c")}
+	_, addr := startBalancer(t, upstreams, ProxyOptions{Strategy: ConsistentHash, HashHeader: "X-User"})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	byUser := func(user string) string {
+		req := proxyRequest("GET", "/", nil)
+		req.Headers.Set("X-User", user)
+		_, body := fetch(t, c, addr, req)
+		return body
+	}
+
+	// Test: The same key always reaches the same upstream
+	first := byUser("alice")
+	for range 5 {
+		assert.Equal(t, first, byUser("alice"))
+	}
+
+	// Test: Different keys are spread over the upstreams
+	seen := map[string]bool{}
+	for _, user := range []string{"u1", "u2", "u3", "u4", "u5", "u6", "u7", "u8", "u9", "u10", "u11", "u12"} {
+		seen[byUser(user)] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
+
+func TestPassiveEjectionAndRetries(t *testing.T) {
+	dead := deadBackend(t)
+	upstreams := []string{dead, namedBackend(t, "alive")}
+	lb, addr := startBalancer(t, upstreams, ProxyOptions{MaxFails: 2, FailTimeout: time.Minute})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Requests that aren't idempotent aren't retried
+	status, _ := fetch(t, c, addr, proxyRequest("POST", "/", []byte("data")))
+	assert.Equal(t, response.StatusBadGateway, status)
+
+	// Test: Idempotent requests are retried on another upstream
+	for range 3 {
+		status, body := fetch(t, c, addr, proxyRequest("GET", "/", nil))
+		assert.Equal(t, response.StatusOK, status)
+		assert.Equal(t, "alive", body)
+	}
+
+	// Test: The failing upstream is ejected
+	assert.False(t, lb.backends[0].available(time.Now()))
+	assert.True(t, lb.backends[1].available(time.Now()))
+
+	// Test: With every upstream down, they are still tried
+	lb, addr = startBalancer(t, []string{dead}, ProxyOptions{MaxFails: 1})
+	for range 2 {
+		status, _ := fetch(t, c, addr, proxyRequest("GET", "/", nil))
+		assert.Equal(t, response.StatusBadGateway, status)
+	}
+	assert.False(t, lb.backends[0].available(time.Now()))
+}
+
+func TestActiveHealthChecks(t *testing.T) {
+	var failing atomic.Bool
+	flaky := startServer(t, func(w *response.Writer, req *request.Request) {
+		status := response.StatusOK
+		if req.RequestLine.RequestTarget == "/health" && failing.Load() {
+			status = response.StatusServiceUnavailable
+		}
+		body := []byte("flaky")
+		w.WriteStatusLine(status)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	upstreams := []string{"http://" + flaky.Addr().String(), namedBackend(t, "steady")}
+	lb, addr := startBalancer(t, upstreams, ProxyOptions{
+		HealthCheck: HealthCheck{Path: "/health", Interval: 10 * time.Millisecond},
+	})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: A failing health check takes the upstream out
+	failing.Store(true)
+	require.Eventually(t, func() bool {
+		return !lb.backends[0].available(time.Now())
+	}, time.Second, 5*time.Millisecond)
+	for range 4 {
+		_, body := fetch(t, c, addr, proxyRequest("GET", "/", nil))
+		assert.Equal(t, "steady", body)
+	}
+
+	// Test: It comes back once the checks pass again
+	failing.Store(false)
+	require.Eventually(t, func() bool {
+		return lb.backends[0].available(time.Now())
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"app/internal/headers"
+	"app/internal/request"
+	"app/internal/response"
+	"strings"
+	"time"
+)
+
+var conditionalFields = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"}
+
+// CheckPreconditions evaluates the conditional fields of req against
+// the current ETag and Last-Modified of the resource it targets, in the
+// order RFC 9110 section 13.2.2 gives, and returns the status to answer
+// with instead: 304 Not Modified, 412 Precondition Failed, or 0 to go
+// ahead with the request. An empty etag or zero lastModified means the
+// resource doesn't have that validator.
+//
+// The server runs this for GET and HEAD on its own, using the ETag and
+// Last-Modified the handler responds with. Handlers for methods that
+// change the resource, such as PUT, have to call it themselves before
+// making the change.
+func CheckPreconditions(req *request.Request, etag string, lastModified time.Time) response.StatusCode {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	lastModified = lastModified.Truncate(time.Second)
+
+	if ifMatch, exists := req.Headers.Get("If-Match"); exists {
+		if !etagListMatches(ifMatch, etag, strongMatch) {
+			return response.StatusPreconditionFailed
+		}
+	} else if since, exists := req.Headers.Get("If-Unmodified-Since"); exists && !lastModified.IsZero() {
+		t, err := response.ParseTime(since)
+		if err == nil && lastModified.After(t) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch, exists := req.Headers.Get("If-None-Match"); exists {
+		if etagListMatches(ifNoneMatch, etag, weakMatch) {
+			if safe {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if since, exists := req.Headers.Get("If-Modified-Since"); exists && safe && !lastModified.IsZero() {
+		t, err := response.ParseTime(since)
+		if err == nil && !lastModified.After(t) {
+			return response.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// hasPreconditions reports whether req has any conditional fields that
+// CheckPreconditions looks at.
+func hasPreconditions(req *request.Request) bool {
+	for _, field := range conditionalFields {
+		if _, exists := req.Headers.Get(field); exists {
+			return true
+		}
+	}
+	return false
+}
+
+// responsePreconditions checks req against the validators in the
+// headers of the response to it.
+func responsePreconditions(req *request.Request) func(h headers.Headers) response.StatusCode {
+	return func(h headers.Headers) response.StatusCode {
+		etag, _ := h.Get("ETag")
+		var lastModified time.Time
+		if value, exists := h.Get("Last-Modified"); exists {
+			lastModified, _ = response.ParseTime(value)
+		}
+		return CheckPreconditions(req, etag, lastModified)
+	}
+}
+
+// etagListMatches reports whether a field value of "*" or a list of
+// entity-tags matches etag. "*" matches any current representation.
+func etagListMatches(value, etag string, match func(a, b string) bool) bool {
+	if strings.TrimSpace(value) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+	for _, tag := range parseETags(value) {
+		if match(tag, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETags splits a list of entity-tags. Commas are allowed inside
+// the quotes, so it can't just split on them. Malformed members are
+// skipped.
+func parseETags(value string) []string {
+	var tags []string
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags
+		}
+
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			next := strings.IndexByte(value, ',')
+			if next == -1 {
+				return tags
+			}
+			value = value[next:]
+			continue
+		}
+
+		end := strings.IndexByte(value[start+1:], '"')
+		if end == -1 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+}
+
+// strongMatch is the strong comparison of RFC 9110 section 8.8.3.2:
+// both tags strong and identical.
+func strongMatch(a, b string) bool {
+	return !isWeak(a) && !isWeak(b) && a == b
+}
+
+// weakMatch compares tags ignoring whether they are weak.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func isWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
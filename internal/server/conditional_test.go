@@ -0,0 +1,154 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/headers"
+	"app/internal/request"
+	"app/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conditionalRequest(method string, fields map[string]string) *request.Request {
+	req := &request.Request{RequestLine: request.RequestLine{Method: method}, Headers: headers.Headers{}}
+	for name, value := range fields {
+		req.Headers.Set(name, value)
+	}
+	return req
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"v2"`
+	modified := time.Date(2024, time.March, 1, 12, 0, 0, 500, time.UTC)
+	before := response.FormatTime(modified.Add(-time.Hour))
+	at := response.FormatTime(modified)
+	after := response.FormatTime(modified.Add(time.Hour))
+
+	cases := []struct {
+		name     string
+		method   string
+		fields   map[string]string
+		expected response.StatusCode
+	}{
+		{"no conditions", "GET", nil, 0},
+		{"If-Match matches", "PUT", map[string]string{"If-Match": `"v1", "v2"`}, 0},
+		{"If-Match star", "PUT", map[string]string{"If-Match": "*"}, 0},
+		{"If-Match mismatch", "PUT", map[string]string{"If-Match": `"v1"`}, response.StatusPreconditionFailed},
+		{"If-Match is strong", "PUT", map[string]string{"If-Match": `W/"v2"`}, response.StatusPreconditionFailed},
+		{"If-Unmodified-Since passes", "PUT", map[string]string{"If-Unmodified-Since": at}, 0},
+		{"If-Unmodified-Since fails", "PUT", map[string]string{"If-Unmodified-Since": before}, response.StatusPreconditionFailed},
+		{"If-Match takes precedence", "PUT", map[string]string{"If-Match": etag, "If-Unmodified-Since": before}, 0},
+		{"If-None-Match matches weakly", "GET", map[string]string{"If-None-Match": `W/"v2"`}, response.StatusNotModified},
+		{"If-None-Match with commas", "HEAD", map[string]string{"If-None-Match": `"a,b", "v2"`}, response.StatusNotModified},
+		{"If-None-Match mismatch", "GET", map[string]string{"If-None-Match": `"v1"`}, 0},
+		{"If-None-Match on unsafe method", "PUT", map[string]string{"If-None-Match": "*"}, response.StatusPreconditionFailed},
+		{"If-Modified-Since unchanged", "GET", map[string]string{"If-Modified-Since": at}, response.StatusNotModified},
+		{"If-Modified-Since changed", "GET", map[string]string{"If-Modified-Since": before}, 0},
+		{"If-Modified-Since in the future", "GET", map[string]string{"If-Modified-Since": after}, response.StatusNotModified},
+		{"If-Modified-Since invalid", "GET", map[string]string{"If-Modified-Since": "soon"}, 0},
+		{"If-Modified-Since on unsafe method", "POST", map[string]string{"If-Modified-Since": at}, 0},
+		{"If-None-Match takes precedence", "GET", map[string]string{"If-None-Match": `"v1"`, "If-Modified-Since": at}, 0},
+		{"412 before 304", "GET", map[string]string{"If-Match": `"v1"`, "If-None-Match": etag}, response.StatusPreconditionFailed},
+	}
+	// Test: RFC 9110 evaluation order
+	for _, c := range cases {
+		assert.Equal(t, c.expected, CheckPreconditions(conditionalRequest(c.method, c.fields), etag, modified), c.name)
+	}
+
+	// Test: Missing validators never match
+	assert.Equal(t, response.StatusPreconditionFailed, CheckPreconditions(conditionalRequest("PUT", map[string]string{"If-Match": etag}), "", modified))
+	assert.Equal(t, response.StatusCode(0), CheckPreconditions(conditionalRequest("GET", map[string]string{"If-Modified-Since": at}), etag, time.Time{}))
+}
+
+func TestConditionalRequests(t *testing.T) {
+	root := fileTree(t)
+	s := startServer(t, FileServer(root))
+	addr := s.Addr().String()
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	full := getFile(t, c, addr, "GET", "/alphabet.txt", nil)
+	etag := full.headers["etag"]
+	lastModified := full.headers["last-modified"]
+	require.NotEmpty(t, etag)
+	assert.Equal(t, etag, getFile(t, c, addr, "GET", "/alphabet.txt", nil).headers["etag"])
+
+	// Test: A matching If-None-Match gets 304 with the validators and no body
+	resp := getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, response.StatusNotModified, resp.status)
+	assert.Equal(t, etag, resp.headers["etag"])
+	assert.Empty(t, resp.headers["content-length"])
+	assert.Empty(t, resp.body)
+
+	// Test: HEAD is handled the same way
+	resp = getFile(t, c, addr, "HEAD", "/alphabet.txt", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, response.StatusNotModified, resp.status)
+
+	// Test: If-Modified-Since with the current date gets 304, an older one the file
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"If-Modified-Since": lastModified})
+	assert.Equal(t, response.StatusNotModified, resp.status)
+	old := response.FormatTime(time.Now().Add(-24 * time.Hour))
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"If-Modified-Since": old})
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, alphabet, resp.body)
+
+	// Test: A failed If-Match gets 412
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"If-Match": `"stale"`})
+	assert.Equal(t, response.StatusPreconditionFailed, resp.status)
+	assert.Empty(t, resp.body)
+
+	// Test: Conditions are only applied to successful responses
+	resp = getFile(t, c, addr, "GET", "/missing.txt", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, response.StatusNotFound, resp.status)
+
+	// Test: If-Range with the current ETag honors the range, a stale one doesn't
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Range": "bytes=0-2", "If-Range": etag})
+	assert.Equal(t, response.StatusPartialContent, resp.status)
+	assert.Equal(t, "abc", resp.body)
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Range": "bytes=0-2", "If-Range": `"stale"`})
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, alphabet, resp.body)
+}
+
+func TestConditionalCompressedRequests(t *testing.T) {
+	root := fileTree(t)
+	files := FileServer(root)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		w.Compress(acceptEncoding)
+		files(w, req)
+	})
+	addr := s.Addr().String()
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	identity := getFile(t, c, addr, "GET", "/alphabet.txt", nil).headers["etag"]
+	gzipped := getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Accept-Encoding": "gzip"})
+	require.NotEmpty(t, identity)
+
+	// Test: Each content-coding gets its own strong ETag
+	assert.Equal(t, "gzip", gzipped.headers["content-encoding"])
+	assert.Equal(t, strings.TrimSuffix(identity, `"`)+`-gzip"`, gzipped.headers["etag"])
+	deflated := getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Accept-Encoding": "deflate"})
+	assert.NotEqual(t, gzipped.headers["etag"], deflated.headers["etag"])
+
+	// Test: The identity ETag doesn't validate the gzip representation
+	resp := getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": identity})
+	assert.Equal(t, response.StatusOK, resp.status)
+
+	// Test: The gzip ETag does, and the 304 carries it
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": gzipped.headers["etag"]})
+	assert.Equal(t, response.StatusNotModified, resp.status)
+	assert.Equal(t, gzipped.headers["etag"], resp.headers["etag"])
+	assert.Equal(t, "Accept-Encoding", resp.headers["vary"])
+	assert.Empty(t, resp.body)
+
+	// Test: The gzip ETag doesn't validate the identity representation
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"If-None-Match": gzipped.headers["etag"]})
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, alphabet, resp.body)
+}
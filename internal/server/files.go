@@ -0,0 +1,523 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// More ranges than this in one request are ignored and the whole file
+// is sent, so a request can't ask for the same bytes over and over.
+// Ranges adding up to more than the file are ignored the same way.
+const maxRanges = 50
+
+// Content types for extensions that mime.TypeByExtension may not know,
+// depending on the system's mime.types.
+var extraContentTypes = map[string]string{
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+	".ico":  "image/x-icon",
+}
+
+// FileServerOptions configures FileServerWithOptions.
+type FileServerOptions struct {
+	// StripPrefix is removed from the start of the request path before
+	// it is looked up under the root directory. Paths that don't start
+	// with it, or only share part of its last segment, such as
+	// /staticfoo for /static, get 404.
+	StripPrefix string
+	// ListDirectories renders an HTML listing for directories without
+	// an index.html. They are answered with 403 otherwise.
+	ListDirectories bool
+}
+
+// FileServer returns a Handler that serves the files under root, see
+// FileServerWithOptions.
+func FileServer(root string) Handler {
+	return FileServerWithOptions(root, FileServerOptions{})
+}
+
+// FileServerWithOptions returns a Handler that serves the files under
+// root for GET and HEAD requests, with byte ranges supported as in
+// ServeFile. Requests for a directory are answered with its index.html.
+// Nothing outside root can be reached, whether through ".." in the path
+// or through symlinks.
+func FileServerWithOptions(root string, opts FileServerOptions) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if !allowReadOnly(w, req) {
+			return
+		}
+
+		target, query, hasQuery := strings.Cut(req.RequestLine.RequestTarget, "?")
+		urlPath, err := url.PathUnescape(target)
+		if err != nil {
+			writeError(w, response.StatusBadRequest, err)
+			return
+		}
+		rest, found := strings.CutPrefix(urlPath, opts.StripPrefix)
+		if !found || (rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(opts.StripPrefix, "/")) {
+			writeError(w, response.StatusNotFound, errors.New("Not found"))
+			return
+		}
+		urlPath = rest
+		if slices.Contains(strings.Split(urlPath, "/"), "..") || strings.ContainsAny(urlPath, "\\\x00") {
+			writeError(w, response.StatusForbidden, errors.New("Invalid path"))
+			return
+		}
+
+		dir, err := os.OpenRoot(root)
+		if err != nil {
+			req.Log().Error("Error opening file server root", "root", root, "err", err)
+			writeError(w, response.StatusInternalError, errors.New("File server unavailable"))
+			return
+		}
+		defer dir.Close()
+
+		name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+		if name == "" {
+			name = "."
+		}
+		f, info, ok := openFile(w, req, dir, name)
+		if !ok {
+			return
+		}
+		defer f.Close()
+
+		if !info.IsDir() {
+			serveContent(w, req, f, info)
+			return
+		}
+
+		if !strings.HasSuffix(target, "/") {
+			// Relative links in the index page need the slash. Cleaned,
+			// so a target starting with // can't redirect to another
+			// host.
+			location := path.Clean("/"+target) + "/"
+			if hasQuery {
+				location += "?" + query
+			}
+			redirect(w, location)
+			return
+		}
+
+		index, indexInfo, err := openInRoot(dir, path.Join(name, "index.html"))
+		if err == nil {
+			defer index.Close()
+			serveContent(w, req, index, indexInfo)
+			return
+		}
+
+		if !opts.ListDirectories {
+			writeError(w, response.StatusForbidden, errors.New("Directory listing is disabled"))
+			return
+		}
+		listDirectory(w, req, f, urlPath)
+	}
+}
+
+// ServeFile answers a GET or HEAD request with the file at name. It
+// sets Content-Type from the extension (or the first bytes of the file)
+// and Last-Modified, and supports Range and If-Range: a single range is
+// sent as 206 with Content-Range, several as multipart/byteranges, and
+// ranges that don't overlap the file get 416.
+func ServeFile(w *response.Writer, req *request.Request, name string) {
+	if !allowReadOnly(w, req) {
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		writeFileError(w, req, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeFileError(w, req, err)
+		return
+	}
+	if info.IsDir() {
+		writeError(w, response.StatusForbidden, errors.New("Is a directory"))
+		return
+	}
+
+	serveContent(w, req, f, info)
+}
+
+func allowReadOnly(w *response.Writer, req *request.Request) bool {
+	method := req.RequestLine.Method
+	if method == "GET" || method == "HEAD" {
+		return true
+	}
+
+	body := fmt.Appendf(nil, "Error: Method %s is not allowed", method)
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Allow", "GET, HEAD")
+	w.WriteStatusLine(response.StatusMethodNotAllowed)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+	return false
+}
+
+func openFile(w *response.Writer, req *request.Request, dir *os.Root, name string) (*os.File, fs.FileInfo, bool) {
+	f, info, err := openInRoot(dir, name)
+	if err != nil {
+		writeFileError(w, req, err)
+		return nil, nil, false
+	}
+	return f, info, true
+}
+
+func openInRoot(dir *os.Root, name string) (*os.File, fs.FileInfo, error) {
+	f, err := dir.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func writeFileError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, response.StatusNotFound, errors.New("Not found"))
+	case errors.Is(err, fs.ErrPermission):
+		writeError(w, response.StatusForbidden, errors.New("Permission denied"))
+	default:
+		// Includes symlinks that lead out of the root.
+		req.Log().Warn("Error opening file", "err", err)
+		writeError(w, response.StatusNotFound, errors.New("Not found"))
+	}
+}
+
+func redirect(w *response.Writer, location string) {
+	h := response.GetDefaultHeaders(0)
+	h.Set("Location", location)
+	w.WriteStatusLine(response.StatusMovedPermanently)
+	w.WriteHeaders(h)
+	w.WriteBody(nil)
+}
+
+// serveContent sends f, or the ranges of it the request asks for.
+func serveContent(w *response.Writer, req *request.Request, f *os.File, info fs.FileInfo) {
+	size := info.Size()
+	contentType, err := detectContentType(f, info.Name())
+	if err != nil {
+		req.Log().Error("Error reading file", "file", info.Name(), "err", err)
+		writeError(w, response.StatusInternalError, errors.New("Error reading file"))
+		return
+	}
+	etag := fileETag(info)
+
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Connection")
+	h.Replace("Content-Type", contentType)
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Last-Modified", response.FormatTime(info.ModTime()))
+	h.Set("ETag", etag)
+
+	var ranges []byteRange
+	if rangeHeader, exists := req.Headers.Get("Range"); exists && req.RequestLine.Method == "GET" && ifRangeMatches(req, etag, info.ModTime()) {
+		ranges, err = parseRange(rangeHeader, size)
+		if errors.Is(err, errUnsatisfiableRange) {
+			body := []byte("Error: Range not satisfiable")
+			h.Replace("Content-Length", strconv.Itoa(len(body)))
+			h.Replace("Content-Type", "text/plain")
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+			w.WriteHeaders(h)
+			w.WriteBody(body)
+			return
+		}
+		if err != nil {
+			// A Range field that can't be parsed is ignored.
+			ranges = nil
+		}
+	}
+
+	var body io.Reader
+	switch len(ranges) {
+	case 0:
+		h.Replace("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteStatusLine(response.StatusOK)
+		body = f
+	case 1:
+		r := ranges[0]
+		// A limited file rather than a section reader, so the range can
+		// still be sent with sendfile.
+		_, err = f.Seek(r.start, io.SeekStart)
+		if err != nil {
+			writeFileError(w, req, err)
+			return
+		}
+		h.Replace("Content-Length", strconv.FormatInt(r.length, 10))
+		h.Set("Content-Range", r.contentRange(size))
+		w.WriteStatusLine(response.StatusPartialContent)
+		body = io.LimitReader(f, r.length)
+	default:
+		multipart, length := multipartRanges(f, ranges, contentType, size)
+		h.Replace("Content-Length", strconv.FormatInt(length, 10))
+		h.Replace("Content-Type", "multipart/byteranges; boundary="+multipart.boundary)
+		w.WriteStatusLine(response.StatusPartialContent)
+		body = multipart.reader
+	}
+
+	w.WriteHeaders(h)
+	_, err = w.WriteBodyFromReader(body)
+	if err != nil {
+		req.Log().Info("Error sending file", "file", info.Name(), "err", err)
+	}
+}
+
+// detectContentType picks a Content-Type from the file extension, or
+// failing that from whether the start of the file looks like text.
+func detectContentType(f *os.File, name string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if contentType, known := extraContentTypes[ext]; known {
+		return contentType, nil
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType, nil
+	}
+
+	start := make([]byte, 512)
+	n, err := f.ReadAt(start, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	start = start[:n]
+
+	// A multi-byte character may be cut off at the end.
+	for i := 0; i < utf8.UTFMax && len(start) > 0 && !utf8.Valid(start); i++ {
+		start = start[:len(start)-1]
+	}
+	if utf8.Valid(start) && !bytes.ContainsFunc(start, isBinaryControl) {
+		return "text/plain; charset=utf-8", nil
+	}
+	return "application/octet-stream", nil
+}
+
+func isBinaryControl(r rune) bool {
+	return r < ' ' && r != '\t' && r != '\n' && r != '\r' && r != '\f'
+}
+
+// fileETag makes a strong entity-tag from the modification time and
+// size of a file, which change whenever its content does, without
+// reading it.
+func fileETag(info fs.FileInfo) string {
+	return response.StrongETag(fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()))
+}
+
+// ifRangeMatches reports whether a Range field should be honored: when
+// there is no If-Range, or it holds the file's current entity-tag or
+// Last-Modified date (RFC 9110 section 13.1.5).
+func ifRangeMatches(req *request.Request, etag string, modTime time.Time) bool {
+	ifRange, exists := req.Headers.Get("If-Range")
+	if !exists {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return strongMatch(ifRange, etag)
+	}
+	t, err := response.ParseTime(ifRange)
+	return err == nil && t.Equal(modTime.Truncate(time.Second))
+}
+
+var errUnsatisfiableRange = errors.New("no satisfiable ranges")
+
+// byteRange is a range of length bytes from start.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a bytes Range field value (RFC 9110 section 14.1.2)
+// for a file of the given size. Ranges that start past the end are
+// dropped, and errUnsatisfiableRange returned if none are left. Ranges
+// that overlap or touch are merged, see coalesceRanges.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, set, found := strings.Cut(value, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, fmt.Errorf("Unsupported range unit: %q", value)
+	}
+
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, fmt.Errorf("Too many ranges: %d", len(specs))
+	}
+
+	var ranges []byteRange
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, fmt.Errorf("Invalid range: %q", spec)
+		}
+
+		if first == "" {
+			// suffix-range: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid range: %q", spec)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("Invalid range: %q", spec)
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, fmt.Errorf("Invalid range: %q", spec)
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		return nil, fmt.Errorf("Ranges add up to more than the file: %q", value)
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// coalesceRanges merges ranges that overlap or touch, so no byte is
+// sent twice. Ranges that don't are left in the order they were asked
+// for, as RFC 9110 section 14.6 prefers; merged ones come sorted.
+func coalesceRanges(ranges []byteRange) []byteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b byteRange) int {
+		return cmp.Compare(a.start, b.start)
+	})
+
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.start > last.start+last.length {
+			merged = append(merged, r)
+			continue
+		}
+		last.length = max(last.length, r.start+r.length-last.start)
+	}
+
+	if len(merged) == len(ranges) {
+		return ranges
+	}
+	return merged
+}
+
+type multipartBody struct {
+	boundary string
+	reader   io.Reader
+}
+
+// multipartRanges builds a multipart/byteranges body (RFC 9110 section
+// 14.6) for ranges of f and returns it with its total length.
+func multipartRanges(f *os.File, ranges []byteRange, contentType string, size int64) (multipartBody, int64) {
+	boundary := make([]byte, 16)
+	rand.Read(boundary)
+	m := multipartBody{boundary: hex.EncodeToString(boundary)}
+
+	var parts []io.Reader
+	var length int64
+	for i, r := range ranges {
+		partHeader := fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n", m.boundary, contentType, r.contentRange(size))
+		if i > 0 {
+			partHeader = "\r\n" + partHeader
+		}
+		parts = append(parts, strings.NewReader(partHeader), io.NewSectionReader(f, r.start, r.length))
+		length += int64(len(partHeader)) + r.length
+	}
+	closing := "\r\n--" + m.boundary + "--\r\n"
+	parts = append(parts, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	m.reader = io.MultiReader(parts...)
+	return m, length
+}
+
+// listDirectory sends an HTML page linking to the entries of dir.
+func listDirectory(w *response.Writer, req *request.Request, dir *os.File, urlPath string) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		req.Log().Error("Error reading directory", "path", urlPath, "err", err)
+		writeError(w, response.StatusInternalError, errors.New("Error reading directory"))
+		return
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	title := html.EscapeString("Index of " + urlPath)
+	var page bytes.Buffer
+	fmt.Fprintf(&page, "<html>\n  <head>\n    <title>%s</title>\n  </head>\n  <body>\n    <h1>%s</h1>\n    <ul>\n", title, title)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		// The ./ keeps a name with a colon from reading as a scheme.
+		href := "./" + (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(&page, "      <li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	page.WriteString("    </ul>\n  </body>\n</html>")
+
+	h := response.GetDefaultHeaders(page.Len())
+	h.Remove("Connection")
+	h.Replace("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.WriteBody(page.Bytes())
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/request"
+	"app/internal/response"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const alphabet = "abcdefghijklmnopqrstuvwxyz"
+
+// fileTree creates a directory to serve, next to a file that must not
+// be reachable from it.
+func fileTree(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "public")
+	files := map[string]string{
+		"public/alphabet.txt":      alphabet,
+		"public/page.html":         "<p>page</p>",
+		"public/data.unknownext":   "plain text content",
+		"public/blob.unknownext":   "\x00\x01\x02binary",
+		"public/site/index.html":   "<h1>index</h1>",
+		"public/docs/b & c.txt":    "b",
+		"public/docs/a.txt":        "a",
+		"public/docs/nested/x.txt": "x",
+		"secret.txt":               "top secret",
+	}
+	for name, content := range files {
+		path := filepath.Join(base, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	return root
+}
+
+type fileResponse struct {
+	status  response.StatusCode
+	headers map[string]string
+	body    string
+}
+
+func getFile(t *testing.T, c *client.Client, addr, method, target string, fields map[string]string) fileResponse {
+	t.Helper()
+	req := proxyRequest(method, target, nil)
+	for name, value := range fields {
+		req.Headers.Set(name, value)
+	}
+	resp, err := c.Do(addr, req)
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	return fileResponse{resp.StatusLine.StatusCode, resp.Headers, string(body)}
+}
+
+func TestFileServer(t *testing.T) {
+	root := fileTree(t)
+	s := startServer(t, FileServerWithOptions(root, FileServerOptions{StripPrefix: "/static", ListDirectories: true}))
+	addr := s.Addr().String()
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Files are served with type, length and validators
+	resp := getFile(t, c, addr, "GET", "/static/alphabet.txt", nil)
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, alphabet, resp.body)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.headers["content-type"])
+	assert.Equal(t, "26", resp.headers["content-length"])
+	assert.Equal(t, "bytes", resp.headers["accept-ranges"])
+	info, err := os.Stat(filepath.Join(root, "alphabet.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, info.ModTime().UTC().Format(response.TimeFormat), resp.headers["last-modified"])
+
+	// Test: Content types
+	assert.Equal(t, "text/html; charset=utf-8", getFile(t, c, addr, "GET", "/static/page.html", nil).headers["content-type"])
+	assert.Equal(t, "text/plain; charset=utf-8", getFile(t, c, addr, "GET", "/static/data.unknownext", nil).headers["content-type"])
+	assert.Equal(t, "application/octet-stream", getFile(t, c, addr, "GET", "/static/blob.unknownext", nil).headers["content-type"])
+
+	// Test: HEAD has the headers but no body
+	resp = getFile(t, c, addr, "HEAD", "/static/alphabet.txt", nil)
+	assert.Equal(t, "26", resp.headers["content-length"])
+	assert.Empty(t, resp.body)
+
+	// Test: Directories serve index.html, after a redirect to add the slash
+	resp = getFile(t, c, addr, "GET", "/static/site", nil)
+	assert.Equal(t, response.StatusMovedPermanently, resp.status)
+	assert.Equal(t, "/static/site/", resp.headers["location"])
+	resp = getFile(t, c, addr, "GET", "/static/site/", nil)
+	assert.Equal(t, "<h1>index</h1>", resp.body)
+	resp = getFile(t, c, addr, "GET", "/static//site", nil)
+	assert.Equal(t, "/static/site/", resp.headers["location"])
+	resp = getFile(t, c, addr, "GET", "/static/site?lang=en&x=%2F", nil)
+	assert.Equal(t, "/static/site/?lang=en&x=%2F", resp.headers["location"])
+
+	// Test: Directory listings, sorted and escaped
+	resp = getFile(t, c, addr, "GET", "/static/docs/", nil)
+	assert.Equal(t, response.StatusOK, resp.status)
+	a := strings.Index(resp.body, `<a href="./a.txt">a.txt</a>`)
+	bc := strings.Index(resp.body, `<a href="./b%20&amp;%20c.txt">b &amp; c.txt</a>`)
+	nested := strings.Index(resp.body, `<a href="./nested/">nested/</a>`)
+	assert.True(t, a >= 0 && bc > a && nested > bc, resp.body)
+
+	// Test: Percent-encoded names
+	assert.Equal(t, "b", getFile(t, c, addr, "GET", "/static/docs/b%20%26%20c.txt", nil).body)
+
+	// Test: The prefix only matches whole path segments
+	assert.Equal(t, response.StatusNotFound, getFile(t, c, addr, "GET", "/staticalphabet.txt", nil).status)
+	assert.Equal(t, response.StatusNotFound, getFile(t, c, addr, "GET", "/static.txt", nil).status)
+	assert.Equal(t, "/static/", getFile(t, c, addr, "GET", "/static", nil).headers["location"])
+	withSlash := startServer(t, FileServerWithOptions(root, FileServerOptions{StripPrefix: "/static/"}))
+	assert.Equal(t, alphabet, getFile(t, c, withSlash.Addr().String(), "GET", "/static/alphabet.txt", nil).body)
+
+	// Test: Missing files and other methods
+	assert.Equal(t, response.StatusNotFound, getFile(t, c, addr, "GET", "/static/missing.txt", nil).status)
+	resp = getFile(t, c, addr, "DELETE", "/static/alphabet.txt", nil)
+	assert.Equal(t, response.StatusMethodNotAllowed, resp.status)
+	assert.Equal(t, "GET, HEAD", resp.headers["allow"])
+
+	// Test: Nothing outside the root can be reached
+	for _, target := range []string{"/static/../secret.txt", "/static/%2e%2e/secret.txt", "/static/docs/..%2f..%2fsecret.txt", "/static/escape.txt"} {
+		resp = getFile(t, c, addr, "GET", target, nil)
+		assert.NotEqual(t, response.StatusOK, resp.status, target)
+		assert.NotContains(t, resp.body, "top secret", target)
+	}
+
+	// Test: Listing is off by default
+	s = startServer(t, FileServer(root))
+	assert.Equal(t, response.StatusForbidden, getFile(t, c, s.Addr().String(), "GET", "/docs/", nil).status)
+
+	// Test: A target starting with // doesn't redirect to another host
+	resp = getFile(t, c, s.Addr().String(), "GET", "//site", nil)
+	assert.Equal(t, response.StatusMovedPermanently, resp.status)
+	assert.Equal(t, "/site/", resp.headers["location"])
+}
+
+func TestFileServerRanges(t *testing.T) {
+	root := fileTree(t)
+	s := startServer(t, FileServer(root))
+	addr := s.Addr().String()
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+	rangeOf := func(value string) fileResponse {
+		return getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Range": value})
+	}
+
+	// Test: Single ranges
+	resp := rangeOf("bytes=0-4")
+	assert.Equal(t, response.StatusPartialContent, resp.status)
+	assert.Equal(t, "abcde", resp.body)
+	assert.Equal(t, "bytes 0-4/26", resp.headers["content-range"])
+	assert.Equal(t, "5", resp.headers["content-length"])
+	assert.Equal(t, "xyz", rangeOf("bytes=-3").body)
+	assert.Equal(t, "yz", rangeOf("bytes=24-").body)
+	assert.Equal(t, "xyz", rangeOf("bytes=23-100").body)
+
+	// Test: Several ranges come as multipart/byteranges
+	resp = rangeOf("bytes=0-1, 24-25")
+	assert.Equal(t, response.StatusPartialContent, resp.status)
+	mediaType, params, found := strings.Cut(resp.headers["content-type"], "; boundary=")
+	require.True(t, found)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	expected := "--" + params + "\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 0-1/26\r\n\r\nab\r\n" +
+		"--" + params + "\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 24-25/26\r\n\r\nyz\r\n" +
+		"--" + params + "--\r\n"
+	assert.Equal(t, expected, resp.body)
+	assert.Equal(t, len(expected), len(resp.body))
+
+	// Test: Overlapping and adjacent ranges are merged
+	resp = rangeOf("bytes=0-2, 1-4, 5-6")
+	assert.Equal(t, response.StatusPartialContent, resp.status)
+	assert.Equal(t, "abcdefg", resp.body)
+	assert.Equal(t, "bytes 0-6/26", resp.headers["content-range"])
+	resp = rangeOf("bytes=24-25, 0-1, 1-2")
+	mediaType, params, _ = strings.Cut(resp.headers["content-type"], "; boundary=")
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	assert.Equal(t, 2, strings.Count(resp.body, "Content-Range: "))
+	assert.Less(t, strings.Index(resp.body, "bytes 0-2/26"), strings.Index(resp.body, "bytes 24-25/26"))
+
+	// Test: Ranges asking for more than the file get it once
+	resp = rangeOf("bytes=" + strings.Repeat("0-,", 40))
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, alphabet, resp.body)
+
+	// Test: Unsatisfiable ranges get 416
+	resp = rangeOf("bytes=26-30")
+	assert.Equal(t, response.StatusRangeNotSatisfiable, resp.status)
+	assert.Equal(t, "bytes */26", resp.headers["content-range"])
+
+	// Test: Invalid ranges are ignored
+	for _, value := range []string{"bytes=5-2", "items=0-1", "bytes=a-b", "bytes=" + strings.Repeat("0-1,", 60)} {
+		resp = rangeOf(value)
+		assert.Equal(t, response.StatusOK, resp.status, value)
+		assert.Equal(t, alphabet, resp.body, value)
+	}
+
+	// Test: If-Range with the current date honors the range
+	lastModified := getFile(t, c, addr, "HEAD", "/alphabet.txt", nil).headers["last-modified"]
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Range": "bytes=0-2", "If-Range": lastModified})
+	assert.Equal(t, "abc", resp.body)
+
+	// Test: An outdated If-Range gets the whole file
+	old := time.Now().Add(-time.Hour).UTC().Format(response.TimeFormat)
+	resp = getFile(t, c, addr, "GET", "/alphabet.txt", map[string]string{"Range": "bytes=0-2", "If-Range": old})
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, alphabet, resp.body)
+
+	// Test: Ranges are ignored for HEAD
+	resp = getFile(t, c, addr, "HEAD", "/alphabet.txt", map[string]string{"Range": "bytes=0-2"})
+	assert.Equal(t, response.StatusOK, resp.status)
+	assert.Equal(t, "26", resp.headers["content-length"])
+}
+
+func TestServeFile(t *testing.T) {
+	root := fileTree(t)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		ServeFile(w, req, filepath.Join(root, "alphabet.txt"))
+	})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Any target gets the one file, with range support
+	resp := getFile(t, c, s.Addr().String(), "GET", "/video", map[string]string{"Range": "bytes=1-2"})
+	assert.Equal(t, response.StatusPartialContent, resp.status)
+	assert.Equal(t, "bc", resp.body)
+}
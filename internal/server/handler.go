@@ -3,6 +3,50 @@ package server
 import (
 	"app/internal/request"
 	"app/internal/response"
+	"errors"
+	"fmt"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
+
+// DecodeBody wraps next so that requests sent with a Content-Encoding
+// reach it with the body already decompressed. Bodies that decode to
+// more than maxSize bytes are answered with 413, and unknown codings
+// with 415.
+func DecodeBody(maxSize int64, next Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := req.DecodeBody(maxSize)
+		if errors.Is(err, request.ErrUnsupportedEncoding) {
+			w.WriteStatusLine(response.StatusUnsupportedMediaType)
+			body := fmt.Appendf(nil, "Error decoding request body: %v", err)
+			headers := response.GetDefaultHeaders(len(body))
+			headers.Set("Accept-Encoding", request.SupportedEncodings)
+			w.WriteHeaders(headers)
+			w.WriteBody(body)
+			return
+		}
+		if errors.Is(err, request.ErrDecodedBodyTooLarge) {
+			writeError(w, response.StatusPayloadTooLarge, err)
+			return
+		}
+		if err != nil {
+			writeError(w, response.StatusBadRequest, err)
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+// writeError sends a plain text response describing err.
+func writeError(w *response.Writer, statusCode response.StatusCode, err error) {
+	body := fmt.Appendf(nil, "Error: %v", err)
+	headers := response.GetDefaultHeaders(len(body))
+	err = w.WriteStatusLine(statusCode)
+	if err != nil {
+		// A response was already started or the connection hijacked.
+		return
+	}
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"bufio"
+	"crypto/tls"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hijackHandler takes over connections to /hijack and speaks a line
+// protocol on them, starting with whatever was buffered, after the
+// handler has returned. Other requests get their body echoed on a
+// persistent connection.
+func hijackHandler(t *testing.T) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget != "/hijack" {
+			h := response.GetDefaultHeaders(len(req.Body))
+			h.Remove("Connection")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(h)
+			w.WriteBody(req.Body)
+			return
+		}
+
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		assert.ErrorIs(t, w.WriteStatusLine(response.StatusOK), response.ErrHijacked)
+		go func() {
+			defer conn.Close()
+			conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: lines\r\nConnection: Upgrade\r\n\r\n"))
+			lines := bufio.NewScanner(io.MultiReader(strings.NewReader(string(buffered)), conn))
+			for lines.Scan() {
+				if lines.Text() == "quit" {
+					return
+				}
+				conn.Write([]byte("got " + lines.Text() + "\n"))
+			}
+		}()
+	}
+}
+
+func TestHijack(t *testing.T) {
+	s := startServer(t, hijackHandler(t))
+	upgrade := "GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: Bytes sent along with the request are handed over, and the
+	// connection outlives the handler
+	conn := dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte(upgrade + "early\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for line := ""; line != "\r\n"; {
+		line, err = reader.ReadString('\n')
+		require.NoError(t, err)
+	}
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "got early\n", line)
+	time.Sleep(20 * time.Millisecond)
+	_, err = conn.Write([]byte("later\nquit\n"))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "got later\n", string(rest))
+
+	// Test: A persistent connection can be hijacked on a later request,
+	// with pipelined bytes handed over
+	conn = dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi" + upgrade + "piped\nquit\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	first, second, found := strings.Cut(string(raw), "HTTP/1.1 101")
+	require.True(t, found, string(raw))
+	assert.True(t, strings.HasPrefix(first, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(first, "\r\n\r\nhi"))
+	assert.True(t, strings.HasSuffix(second, "\r\n\r\ngot piped\n"), second)
+
+	// Test: An unread body is part of what is handed over
+	conn = dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("POST /hijack HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nbody\nquit\n"))
+	require.NoError(t, err)
+	raw, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "100 Continue")
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\ngot body\n"), string(raw))
+}
+
+func TestHijackTLS(t *testing.T) {
+	files, pool := certFiles(t, t.TempDir(), "server", "localhost")
+	s := startServer(t, hijackHandler(t), WithTLS(TLSOptions{Certificates: []CertificateFiles{files}}))
+
+	// Test: TLS connections are handed over decrypted
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\nsecret\nquit\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\ngot secret\n"), string(raw))
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/headers"
+	"app/internal/request"
+	"app/internal/response"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Fields that only apply to a single connection and are never
+// forwarded (RFC 9110 section 7.6.1), on top of any listed in the
+// Connection field itself. Transfer-Encoding is left to the framing of
+// each side.
+var hopByHopFields = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// ReverseProxy returns a Handler that forwards every request to the
+// upstream URL, e.g. "http://localhost:8080" or "https://example.com/api",
+// and relays the response back. See ReverseProxyWithOptions.
+func ReverseProxy(upstream string) (Handler, error) {
+	return ReverseProxyWithOptions(upstream, ProxyOptions{})
+}
+
+// ReverseProxyWithOptions returns a Handler that forwards every request
+// to the upstream URL. The method, body and end-to-end header fields go
+// upstream as they came in, with the target appended to the upstream
+// path, Host set to the upstream host, and Forwarded and X-Forwarded-*
+// fields describing the client. The upstream status and end-to-end
+// header fields are relayed back, with the body streamed as chunks and
+// any trailers kept. Upstreams that can't be reached get 502.
+//
+// Active health checks can only be stopped through NewLoadBalancer, so
+// opts.HealthCheck is ignored here.
+func ReverseProxyWithOptions(upstream string, opts ProxyOptions) (Handler, error) {
+	opts.HealthCheck = HealthCheck{}
+	lb, err := NewLoadBalancer([]string{upstream}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return lb.Handler(), nil
+}
+
+// upstream is a server requests can be proxied to.
+type upstream struct {
+	url     *url.URL
+	address string
+	client  *client.Client
+}
+
+func newUpstream(rawURL string, c *client.Client, responseHeaderTimeout time.Duration) (*upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid upstream URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid upstream URL %q: must be http:// or https:// with a host", rawURL)
+	}
+
+	if c == nil {
+		c = &client.Client{ResponseHeaderTimeout: responseHeaderTimeout}
+		if u.Scheme == "https" {
+			c.TLSConfig = &tls.Config{}
+		}
+	}
+
+	return &upstream{url: u, address: upstreamAddress(u), client: c}, nil
+}
+
+// upstreamAddress returns host:port for u, filling in the default port
+// for its scheme.
+func upstreamAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// outgoingRequest builds the request sent upstream from the one that
+// came in, which must have its body read already.
+func (u *upstream) outgoingRequest(req *request.Request, stripPrefix string) *request.Request {
+	outHeaders := headers.Headers{}
+	outHeaders.Merge(req.Headers)
+	removeHopByHop(outHeaders)
+	// The body is read in full and sent with its own Content-Length,
+	// and any Expect was already answered.
+	outHeaders.Remove("Content-Length")
+	outHeaders.Remove("Trailer")
+	outHeaders.Remove("Expect")
+
+	addForwarded(outHeaders, req)
+	outHeaders.Replace("Host", u.url.Host)
+
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.RequestLine.Method,
+			RequestTarget: u.target(req.RequestLine.RequestTarget, stripPrefix),
+			HttpVersion:   "1.1",
+		},
+		Headers: outHeaders,
+		Body:    req.Body,
+	}
+}
+
+// target maps an incoming request target onto the upstream URL's path.
+func (u *upstream) target(target, stripPrefix string) string {
+	if target == "*" {
+		return target
+	}
+	if !strings.HasPrefix(target, "/") {
+		// Absolute-form, keep only the path and query.
+		parsed, err := url.Parse(target)
+		if err == nil {
+			target = parsed.RequestURI()
+		}
+	}
+
+	target = strings.TrimPrefix(target, stripPrefix)
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+	return strings.TrimSuffix(u.url.Path, "/") + target
+}
+
+// addForwarded records the client and the host it asked for, both in
+// the standard Forwarded field (RFC 7239) and the older X-Forwarded-*
+// fields. Values from proxies further out are kept and appended to.
+func addForwarded(h headers.Headers, req *request.Request) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+	host, _ := req.Host()
+
+	forwardedFor := clientIP
+	if strings.Contains(clientIP, ":") {
+		forwardedFor = "[" + clientIP + "]"
+	}
+	forwarded := "for=" + forwardedValue(forwardedFor)
+	if host != "" {
+		forwarded += ";host=" + forwardedValue(host)
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	forwarded += ";proto=" + proto
+
+	h.Set("Forwarded", forwarded)
+	h.Set("X-Forwarded-For", clientIP)
+	if host != "" {
+		h.Replace("X-Forwarded-Host", host)
+	}
+	h.Replace("X-Forwarded-Proto", proto)
+}
+
+// forwardedValue quotes a Forwarded parameter value unless it is a
+// token.
+func forwardedValue(value string) string {
+	if headers.IsToken(value) {
+		return value
+	}
+	return `"` + value + `"`
+}
+
+// removeHopByHop deletes connection-specific fields, including the ones
+// the Connection field names.
+func removeHopByHop(h headers.Headers) {
+	if connection, exists := h.Get("Connection"); exists {
+		for _, name := range strings.Split(connection, ",") {
+			h.Remove(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopFields {
+		h.Remove(name)
+	}
+}
+
+// relayResponse writes the upstream response back to the client.
+func relayResponse(w *response.Writer, req *request.Request, resp *client.Response) {
+	statusCode := resp.StatusLine.StatusCode
+	if statusCode == response.StatusSwitchingProtocols {
+		writeError(w, response.StatusBadGateway, errors.New("Upstream switched protocols"))
+		return
+	}
+
+	outHeaders := headers.Headers{}
+	outHeaders.Merge(resp.Headers)
+	removeHopByHop(outHeaders)
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		req.Log().Info("Error writing status line", "err", err)
+		return
+	}
+
+	if req.RequestLine.Method == "HEAD" || statusCode == response.StatusNoContent || statusCode == response.StatusNotModified {
+		// No body, but Content-Length still describes the one a GET
+		// would get.
+		w.WriteHeaders(outHeaders)
+		w.WriteBody(nil)
+		return
+	}
+
+	outHeaders.Remove("Content-Length")
+	outHeaders.Replace("Transfer-Encoding", "chunked")
+	w.WriteHeaders(outHeaders)
+
+	_, err = w.WriteChunkedBodyFromReaderWithOptions(resp.Body, response.ChunkOptions{Mode: response.ChunkPassThrough})
+	if err != nil {
+		// Leaving out the last chunk tells the client the body is
+		// incomplete.
+		req.Log().Info("Error relaying upstream body", "err", err)
+		return
+	}
+
+	err = w.WriteTrailers(resp.Trailers)
+	if err != nil {
+		req.Log().Info("Error writing trailers", "err", err)
+	}
+}
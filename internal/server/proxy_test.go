@@ -0,0 +1,225 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/headers"
+	"app/internal/request"
+	"app/internal/response"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startBackend starts an upstream server that hands every request it
+// gets to the returned channel before running handler.
+func startBackend(t *testing.T, handler Handler) (*Server, chan *request.Request) {
+	t.Helper()
+	received := make(chan *request.Request, 10)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		req.ReadBody()
+		received <- req
+		handler(w, req)
+	})
+	return s, received
+}
+
+func startProxy(t *testing.T, upstream string, opts ProxyOptions) *Server {
+	t.Helper()
+	proxy, err := ReverseProxyWithOptions(upstream, opts)
+	require.NoError(t, err)
+	return startServer(t, proxy)
+}
+
+func proxyRequest(method, target string, body []byte) *request.Request {
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     headers.Headers{},
+		Body:        body,
+	}
+	req.Headers.Set("Host", "proxy.example.com")
+	return req
+}
+
+func TestReverseProxy(t *testing.T) {
+	backend, received := startBackend(t, func(w *response.Writer, req *request.Request) {
+		body := []byte("not here")
+		w.WriteStatusLine(response.StatusNotFound)
+		h := response.GetDefaultHeaders(len(body))
+		h.Remove("Connection")
+		h.Set("X-Backend", "one")
+		h.Set("Keep-Alive", "timeout=5")
+		h.Set("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+		h.Set("Set-Cookie", "b=2")
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	})
+	proxy := startProxy(t, "http://"+backend.Addr().String()+"/base", ProxyOptions{StripPrefix: "/api"})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	req := proxyRequest("POST", "/api/items?id=1", []byte("hello"))
+	req.Headers.Set("Content-Type", "text/plain")
+	req.Headers.Set("Connection", "X-Secret")
+	req.Headers.Set("X-Secret", "hop")
+	req.Headers.Set("X-Forwarded-For", "203.0.113.7")
+	resp, err := c.Do(proxy.Addr().String(), req)
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+
+	// Test: Method, target, body and end-to-end fields go upstream
+	upstreamReq := <-received
+	assert.Equal(t, "POST", upstreamReq.RequestLine.Method)
+	assert.Equal(t, "/base/items?id=1", upstreamReq.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(upstreamReq.Body))
+	assert.Equal(t, "text/plain", upstreamReq.Headers["content-type"])
+
+	// Test: Hop-by-hop fields are stripped
+	assert.NotContains(t, upstreamReq.Headers, "x-secret")
+	assert.NotEqual(t, "X-Secret", upstreamReq.Headers["connection"])
+
+	// Test: Host is the upstream and the client is recorded
+	assert.Equal(t, backend.Addr().String(), upstreamReq.Headers["host"])
+	assert.Equal(t, "203.0.113.7, 127.0.0.1", upstreamReq.Headers["x-forwarded-for"])
+	assert.Equal(t, "proxy.example.com", upstreamReq.Headers["x-forwarded-host"])
+	assert.Equal(t, "http", upstreamReq.Headers["x-forwarded-proto"])
+	assert.Equal(t, "for=127.0.0.1;host=proxy.example.com;proto=http", upstreamReq.Headers["forwarded"])
+
+	// Test: Upstream status, headers and body come back
+	assert.Equal(t, response.StatusNotFound, resp.StatusLine.StatusCode)
+	assert.Equal(t, "one", resp.Headers["x-backend"])
+	assert.NotContains(t, resp.Headers, "keep-alive")
+	assert.Equal(t, "not here", string(body))
+
+	// Test: Set-Cookie fields are relayed one by one
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, resp.Headers.Values("Set-Cookie"))
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	backend, _ := startBackend(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetChunkedHeaders()
+		h.Remove("Connection")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.Headers{}
+		trailers.Set("X-Checksum", "abc123")
+		w.WriteTrailers(trailers)
+	})
+	proxy := startProxy(t, "http://"+backend.Addr().String(), ProxyOptions{})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Chunked bodies are streamed with their trailers
+	resp, err := c.Do(proxy.Addr().String(), proxyRequest("GET", "/stream", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "chunked", resp.Headers["transfer-encoding"])
+	assert.Equal(t, "X-Checksum", resp.Headers["trailer"])
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc123", resp.Trailers["x-checksum"])
+
+	// Test: HEAD gets the headers without a body
+	resp, err = c.Do(proxy.Addr().String(), proxyRequest("HEAD", "/stream", nil))
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusOK, resp.StatusLine.StatusCode)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, body)
+}
+
+// countingBody adds the number of bytes read to the trailers at EOF.
+type countingBody struct {
+	io.ReadCloser
+	resp *client.Response
+	n    int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += n
+	if err == io.EOF {
+		b.resp.Trailers.Replace("X-Length", strconv.Itoa(b.n))
+	}
+	return n, err
+}
+
+func TestReverseProxyModifyResponse(t *testing.T) {
+	backend, _ := startBackend(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(11))
+		w.WriteBody([]byte("hello world"))
+	})
+	proxy := startProxy(t, "http://"+backend.Addr().String(), ProxyOptions{
+		ModifyResponse: func(req *request.Request, resp *client.Response) {
+			resp.Headers.Set("Trailer", "X-Length")
+			resp.Body = &countingBody{ReadCloser: resp.Body, resp: resp}
+		},
+	})
+	c := &client.Client{}
+	t.Cleanup(c.CloseIdleConnections)
+
+	// Test: Trailers filled in by the body at EOF are sent after it
+	resp, err := c.Do(proxy.Addr().String(), proxyRequest("GET", "/", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "X-Length", resp.Headers["trailer"])
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "11", resp.Trailers["x-length"])
+
+	// Test: The connection is still reused afterwards
+	resp, err = c.Do(proxy.Addr().String(), proxyRequest("GET", "/", nil))
+	require.NoError(t, err)
+	body, err = resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+}
+
+func TestReverseProxyErrors(t *testing.T) {
+	// Test: Invalid upstream URLs
+	_, err := ReverseProxy("ftp://example.com")
+	assert.Error(t, err)
+	_, err = ReverseProxy("/just/a/path")
+	assert.Error(t, err)
+
+	// Test: Unreachable upstream gives 502
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	proxy := startProxy(t, "http://"+addr, ProxyOptions{})
+	resp := roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"), resp)
+
+	// Test: An upstream that never answers gives 502 once the timeout
+	// runs out
+	stalled, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { stalled.Close() })
+	go func() {
+		for {
+			conn, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	proxy = startProxy(t, "http://"+stalled.Addr().String(), ProxyOptions{ResponseHeaderTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	resp = roundTrip(t, proxy, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"), resp)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
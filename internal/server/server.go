@@ -3,26 +3,140 @@ package server
 import (
 	"app/internal/request"
 	"app/internal/response"
+	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"sync/atomic"
+	"time"
 )
 
-const address = "localhost:42069"
-
 // Contains the state of the server
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	closed   atomic.Bool
+	listener       net.Listener
+	handler        Handler
+	closed         atomic.Bool
+	continuePolicy ContinuePolicy
+	methods        map[string]bool
+	parseOptions   request.ParseOptions
+	tlsOptions     *TLSOptions
+	logger         *slog.Logger
+	connState      func(net.Conn, ConnState)
+	rejectHooks    []func(RejectedRequest)
+	// The last connection ID handed out.
+	connIDs atomic.Uint64
+}
+
+// ConnState is a step in the life of a connection, see WithConnState.
+type ConnState int
+
+const (
+	// StateNew is a connection that has just been accepted.
+	StateNew ConnState = iota
+	// StateClosed is a connection the server has closed.
+	StateClosed
+	// StateHijacked is a connection a handler has taken over. The server
+	// doesn't report anything more about it.
+	StateHijacked
+)
+
+// Option configures optional Server behavior in Serve.
+type Option func(*Server)
+
+// ContinuePolicy decides how to answer a request that carries
+// Expect: 100-continue, before its body has been sent:
+//   - 0 defers the decision to the handler. 100 Continue is sent when
+//     the handler calls req.ReadBody, and never if it responds without
+//     reading the body.
+//   - response.StatusContinue sends 100 Continue right away and reads
+//     the body before the handler runs.
+//   - any other status, such as 417 or 413, rejects the request
+//     without reading the body.
+type ContinuePolicy func(req *request.Request) response.StatusCode
+
+// WithContinuePolicy sets the policy for Expect: 100-continue requests.
+// Without one, every such request is deferred to the handler.
+func WithContinuePolicy(policy ContinuePolicy) Option {
+	return func(s *Server) {
+		s.continuePolicy = policy
+	}
+}
+
+// WithMethods limits the request methods the server accepts. Requests
+// with any other method get 501 Not Implemented. Without this option
+// every method known to request.LookupMethod is accepted.
+func WithMethods(methods ...string) Option {
+	return func(s *Server) {
+		s.methods = map[string]bool{}
+		for _, method := range methods {
+			s.methods[method] = true
+		}
+	}
+}
+
+// WithLogger sets where the server logs. Without it, slog.Default() is
+// used. Connection events are logged at debug level, requests the
+// server rejects at info level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithConnState calls hook as each connection moves through the steps
+// of ConnState, from the connection's goroutine. It must be safe for
+// concurrent use.
+func WithConnState(hook func(conn net.Conn, state ConnState)) Option {
+	return func(s *Server) {
+		s.connState = hook
+	}
+}
+
+// RejectedRequest describes a request the server answered with an
+// error itself, see WithRejectHook.
+type RejectedRequest struct {
+	// When the response was sent.
+	Time       time.Time
+	RemoteAddr string
+	// Request is nil when the request couldn't be parsed. Its body may
+	// not have been read.
+	Request    *request.Request
+	StatusCode response.StatusCode
+	// Body bytes sent with the error response.
+	BodyBytes int64
+	// Err is the reason, which is nil for ContinuePolicy refusals.
+	Err error
+}
+
+// WithRejectHook calls hook for every request the server answers with
+// an error itself, before or instead of running the handler: ones that
+// can't be parsed, have an unknown method or are refused by the
+// ContinuePolicy. The option can be given more than once, and the
+// hooks are called in order. They must be safe for concurrent use.
+func WithRejectHook(hook func(RejectedRequest)) Option {
+	return func(s *Server) {
+		s.rejectHooks = append(s.rejectHooks, hook)
+	}
+}
+
+// WithStrictParsing makes the server reject requests with ambiguous
+// framing, see request.ParseOptions. Use it whenever the server sits
+// behind a proxy, so the two can't disagree on where a request ends.
+func WithStrictParsing() Option {
+	return func(s *Server) {
+		s.parseOptions.Strict = true
+	}
 }
 
-// Creates a net.Listener and returns a new
-// Server instance. Starts listening for
+// Creates a net.Listener on localhost:port and
+// returns a new Server instance. Port 0 picks
+// a free port, see Addr. Starts listening for
 // requests inside a goroutine.
-func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", address)
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +144,18 @@ func Serve(port int, handler Handler) (*Server, error) {
 	newServer := &Server{
 		listener: listener,
 		handler:  handler,
-		closed:   atomic.Bool{},
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(newServer)
+	}
+	if newServer.tlsOptions != nil {
+		config, err := newTLSConfig(*newServer.tlsOptions, newServer.logger)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		newServer.listener = tls.NewListener(listener, config)
 	}
 
 	go newServer.listen()
@@ -38,53 +163,319 @@ func Serve(port int, handler Handler) (*Server, error) {
 	return newServer, nil
 }
 
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 // Closes the listener and the server
 func (s *Server) Close() error {
-	err := s.listener.Close()
-	if err != nil {
-		return err
-	}
+	// Marked closed first so listen doesn't treat
+	// the Accept error from closing as fatal.
 	s.closed.Store(true)
-	return nil
+	return s.listener.Close()
 }
 
+// Longest pause after a failed Accept before trying again.
+const maxAcceptDelay = time.Second
+
 // Uses a loop to .Accept new connections as
 // they come in, and handles each one in a new
 // goroutine. I used an atomic.Bool to track
 // whether the server is closed or not so that
 // I can ignore connection errors after the
-// server is closed.
+// server is closed. Other errors, like running
+// out of file descriptors, may pass, so Accept
+// is tried again after a growing pause.
 func (s *Server) listen() {
-	for !s.closed.Load() {
-		log.Println("Waiting for request at", address)
-		tcpConn, err := s.listener.Accept()
+	var delay time.Duration
+	for {
+		conn, err := s.listener.Accept()
 		if err != nil {
-			log.Fatalf("Error accepting TCP connection: %v", err)
+			if s.closed.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			s.logger.Error("Error accepting connection", "err", err, "retry_in", delay)
+			time.Sleep(delay)
+			continue
 		}
-		s.handle(tcpConn)
+		delay = 0
+
+		id := s.connIDs.Add(1)
+		go s.handle(conn, s.logger.With("conn", id, "remote", conn.RemoteAddr().String()))
+	}
+}
+
+// How long a persistent connection may sit idle
+// waiting for its next request.
+const idleTimeout = 5 * time.Second
+
+// Handles a connection by answering requests on it until
+// either side wants it closed, then closes the connection.
+// Everything about it is logged to logger.
+func (s *Server) handle(conn net.Conn, logger *slog.Logger) {
+	logger.Debug("Connection opened")
+	s.setConnState(conn, StateNew)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tlsConn.Handshake()
+		if err != nil {
+			logger.Info("TLS handshake failed", "err", err)
+			conn.Close()
+			s.setConnState(conn, StateClosed)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		logger.Debug("TLS handshake done", "server_name", state.ServerName, "version", tls.VersionName(state.Version))
+	}
+
+	requests := 0
+	leftover := &bytes.Reader{}
+	for leftover != nil {
+		var served, hijacked bool
+		leftover, served, hijacked = s.serveRequest(conn, leftover, logger)
+		if served {
+			requests++
+		}
+		if hijacked {
+			// The handler has the connection now.
+			logger.Debug("Connection hijacked", "requests", requests)
+			s.setConnState(conn, StateHijacked)
+			return
+		}
+		if leftover != nil {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+	}
+
+	err := closeConn(conn)
+	s.setConnState(conn, StateClosed)
+	if err != nil {
+		logger.Info("Error closing connection", "err", err, "requests", requests)
+		return
+	}
+	logger.Debug("Connection closed", "requests", requests)
+}
+
+func (s *Server) setConnState(conn net.Conn, state ConnState) {
+	if s.connState != nil {
+		s.connState(conn, state)
+	}
+}
+
+// rejected logs a request the server has answered with an error itself
+// and passes it on to the reject hooks. req is nil if it couldn't be
+// parsed.
+func (s *Server) rejected(w *response.Writer, conn net.Conn, req *request.Request, logger *slog.Logger, err error) {
+	logger.Info("Request rejected", "status", int(w.Status()), "err", err)
+	rejection := RejectedRequest{
+		Time:       time.Now(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		Request:    req,
+		StatusCode: w.Status(),
+		BodyBytes:  w.BodyBytes(),
+		Err:        err,
+	}
+	for _, hook := range s.rejectHooks {
+		hook(rejection)
+	}
+}
+
+// How long and how much closeConn reads from a client that is
+// still sending after its response.
+const (
+	lingerTimeout = time.Second
+	lingerBytes   = 256 << 10
+)
+
+// closeConn closes the sending side first and drains whatever the
+// client still sends before closing completely. Closing a socket with
+// unread data makes the kernel reset the connection, which can throw
+// away a response (e.g. a 400 or 413) before the client has read it.
+func closeConn(conn net.Conn) error {
+	// *net.TCPConn, or *tls.Conn, which sends close_notify.
+	halfCloser, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return conn.Close()
 	}
+
+	err := halfCloser.CloseWrite()
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+		io.CopyN(io.Discard, conn, lingerBytes)
+	}
+	return conn.Close()
 }
 
-// Handles a single connection by writing the response and then closing the connection:
-func (s *Server) handle(conn net.Conn) {
+// serveRequest reads and answers a single request, starting with the
+// bytes left over from the one before. It returns what is left over
+// after this one, or nil when the connection should be closed, whether
+// a request was read at all, and whether the handler hijacked the
+// connection.
+func (s *Server) serveRequest(conn net.Conn, leftover *bytes.Reader, logger *slog.Logger) (*bytes.Reader, bool, bool) {
 	rWriter := response.NewWriter(conn)
 
-	req, err := request.RequestFromReader(conn)
+	req, err := request.RequestHeadersFromReaderWithOptions(io.MultiReader(leftover, conn), s.parseOptions)
 	if err != nil {
-		body := fmt.Appendf(nil, "Error parsing request: %v", err)
-		rWriter.WriteStatusLine(response.StatusBadRequest)
-		headers := response.GetDefaultHeaders(len(body))
-		rWriter.WriteHeaders(headers)
-		rWriter.WriteBody(body)
+		var netErr net.Error
+		if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			// Client closed or left an idle connection.
+			return nil, false, false
+		}
+		writeParseError(rWriter, err)
+		s.rejected(rWriter, conn, nil, logger, err)
+		return nil, false, false
+	}
+	conn.SetReadDeadline(time.Time{})
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.Logger = logger
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
 
-		return
+	if req.RequestLine.HttpVersion == "1.0" {
+		rWriter.SetVersion("1.0")
+	}
+	rWriter.SetKeepAlive(req.KeepAlive())
+
+	_, err = req.Host()
+	if err != nil {
+		writeError(rWriter, response.StatusBadRequest, err)
+		s.rejected(rWriter, conn, req, logger, err)
+		return nil, true, false
+	}
+
+	if !s.acceptsMethod(req.RequestLine.Method) {
+		err = fmt.Errorf("Method %s is not implemented", req.RequestLine.Method)
+		writeError(rWriter, response.StatusNotImplemented, err)
+		s.rejected(rWriter, conn, req, logger, err)
+		return nil, true, false
+	}
+
+	err = s.prepareBody(rWriter, req)
+	if err != nil {
+		writeParseError(rWriter, err)
+		s.rejected(rWriter, conn, req, logger, err)
+		return nil, true, false
+	}
+	if rWriter.Done() {
+		// Rejected before the handler ran.
+		s.rejected(rWriter, conn, req, logger, nil)
+		return nil, true, false
+	}
+
+	if req.RequestLine.Method == "HEAD" {
+		rWriter.DiscardBody()
 	}
+	method := req.RequestLine.Method
+	if (method == "GET" || method == "HEAD") && hasPreconditions(req) {
+		rWriter.SetPreconditions(responsePreconditions(req))
+	}
+
+	watcher := newConnWatcher(conn)
+	rWriter.SetDisconnectNotifier(func() <-chan struct{} {
+		if !req.BodyRead() {
+			// Reading now would take the body from the handler.
+			return nil
+		}
+		return watcher.start()
+	})
+	rWriter.SetHijacker(func() (net.Conn, []byte, error) {
+		watched := watcher.stop()
+		conn.SetDeadline(time.Time{})
+		return conn, unread(req, leftover, watched), nil
+	})
 
 	s.handler(rWriter, req)
 
-	err = conn.Close()
+	watched := watcher.stop()
+	if rWriter.Hijacked() {
+		return nil, true, true
+	}
+	err = rWriter.Finish()
 	if err != nil {
-		log.Fatalf("Error trying to close connection: %v", err)
+		logger.Debug("Error finishing response", "err", err)
+		return nil, true, false
+	}
+	if !rWriter.Persistent() || !req.BodyRead() || watcher.isGone() {
+		return nil, true, false
+	}
+	// The next request may already be partly buffered.
+	return bytes.NewReader(unread(req, leftover, watched)), true, false
+}
+
+// unread returns the bytes read from the connection that aren't part of
+// the request: what the parser buffered, then what it didn't get to of
+// the bytes left over from the request before, then what was read while
+// watching the connection.
+func unread(req *request.Request, leftover *bytes.Reader, watched []byte) []byte {
+	buffered := append([]byte(nil), req.Buffered()...)
+	rest, _ := io.ReadAll(leftover)
+	return append(append(buffered, rest...), watched...)
+}
+
+func (s *Server) acceptsMethod(method string) bool {
+	if s.methods != nil {
+		return s.methods[method]
+	}
+	_, known := request.LookupMethod(method)
+	return known
+}
+
+func writeParseError(w *response.Writer, err error) {
+	statusCode := response.StatusBadRequest
+	if errors.Is(err, request.ErrVersionNotSupported) {
+		statusCode = response.StatusHTTPVersionNotSupported
+	}
+	if errors.Is(err, request.ErrUnsupportedTransferEncoding) {
+		statusCode = response.StatusNotImplemented
+	}
+	if errors.Is(err, request.ErrHeaderTooLarge) {
+		statusCode = response.StatusRequestHeaderFieldsTooLarge
+	}
+
+	body := fmt.Appendf(nil, "Error parsing request: %v", err)
+	w.WriteStatusLine(statusCode)
+	headers := response.GetDefaultHeaders(len(body))
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
+
+// prepareBody reads the request body before the handler runs, except
+// for Expect: 100-continue requests, which are answered according to
+// the server's ContinuePolicy.
+func (s *Server) prepareBody(w *response.Writer, req *request.Request) error {
+	if _, exists := req.Headers.Get("Expect"); !exists {
+		return req.ReadBody()
+	}
+
+	if !req.ExpectsContinue() {
+		writeError(w, response.StatusExpectationFailed, errors.New("Only 100-continue is supported in Expect"))
+		return nil
+	}
+
+	var decision response.StatusCode
+	if s.continuePolicy != nil {
+		decision = s.continuePolicy(req)
+	}
+
+	switch decision {
+	case 0:
+		req.OnBodyRead(func() error {
+			return w.WriteInterim(response.StatusContinue, nil)
+		})
+		return nil
+	case response.StatusContinue:
+		err := w.WriteInterim(response.StatusContinue, nil)
+		if err != nil {
+			return err
+		}
+		return req.ReadBody()
+	default:
+		writeError(w, decision, fmt.Errorf("Request rejected with status %d", decision))
+		return nil
 	}
-	log.Println("Connection received and response sent successfully.")
 }
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler, opts ...Option) *Server {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// roundTrip sends a raw request and returns the raw response.
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	t.Helper()
+	conn := dial(t, s)
+	_, err := conn.Write([]byte(raw))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+var echoHandler Handler = func(w *response.Writer, req *request.Request) {
+	err := req.ReadBody()
+	if err != nil {
+		writeError(w, response.StatusBadRequest, err)
+		return
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+	w.WriteBody(req.Body)
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := startServer(t, echoHandler)
+
+	// Test: 100 Continue is sent once the handler reads the body
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(rest), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(rest), "\r\n\r\nhello"))
+
+	// Test: Handler rejects without reading, so no 100 Continue
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		writeError(w, response.StatusPayloadTooLarge, io.ErrShortBuffer)
+	})
+	resp := roundTrip(t, s, "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5000\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.NotContains(t, resp, "100 Continue")
+
+	// Test: Policy rejects before the handler runs
+	handlerCalled := false
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		handlerCalled = true
+		echoHandler(w, req)
+	}, WithContinuePolicy(func(req *request.Request) response.StatusCode {
+		return response.StatusExpectationFailed
+	}))
+	resp = roundTrip(t, s, "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 417 Expectation Failed\r\n"))
+	assert.False(t, handlerCalled)
+
+	// Test: Policy continues immediately
+	s = startServer(t, echoHandler, WithContinuePolicy(func(req *request.Request) response.StatusCode {
+		return response.StatusContinue
+	}))
+	resp = roundTrip(t, s, "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "hello"))
+
+	// Test: Unknown expectations fail
+	s = startServer(t, echoHandler)
+	resp = roundTrip(t, s, "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: teapot\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 417 Expectation Failed\r\n"))
+}
+
+var keepAliveHandler Handler = func(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.StatusOK)
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Remove("Connection")
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
+
+func TestHTTPVersions(t *testing.T) {
+	s := startServer(t, keepAliveHandler)
+
+	// Test: HTTP/1.0 gets an HTTP/1.0 response and is closed
+	resp := roundTrip(t, s, "GET /old HTTP/1.0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.0 200 OK\r\n"))
+	assert.Contains(t, resp, "connection: close\r\n")
+	assert.True(t, strings.HasSuffix(resp, "/old"))
+
+	// Test: Unknown major version
+	resp = roundTrip(t, s, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 505 HTTP Version Not Supported\r\n"))
+
+	// Test: Header sections that are too large
+	resp = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: "+strings.Repeat("v", 70<<10)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 431 Request Header Fields Too Large\r\n"), resp)
+
+	// Test: HTTP/1.1 pipelined requests share the connection
+	resp = roundTrip(t, s,
+		"GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	)
+	assert.Equal(t, 3, strings.Count(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "/three"))
+	assert.Contains(t, resp, "/one")
+	assert.Contains(t, resp, "/two")
+
+	// Test: HTTP/1.0 keep-alive on request
+	conn := dial(t, s)
+	reader := bufio.NewReader(conn)
+	for _, target := range []string{"/a", "/b"} {
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.0 200 OK\r\n", line)
+		for line != "\r\n" {
+			line, err = reader.ReadString('\n')
+			require.NoError(t, err)
+		}
+		body := make([]byte, 2)
+		_, err = io.ReadFull(reader, body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+	}
+}
+
+func TestPipelinedLeftover(t *testing.T) {
+	s := startServer(t, keepAliveHandler)
+
+	// Test: Requests left over beyond what the parser reads next are kept
+	// A large first request grows the read buffer, so more is left over
+	// than the next request's buffer takes in at once.
+	var raw strings.Builder
+	raw.WriteString("GET /big HTTP/1.1\r\nHost: localhost\r\nX-Pad: " + strings.Repeat("a", 10000) + "\r\n\r\n")
+	for i := range 200 {
+		fmt.Fprintf(&raw, "GET /%03d HTTP/1.1\r\nHost: localhost\r\n\r\n", i)
+	}
+	raw.WriteString("GET /last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	resp := roundTrip(t, s, raw.String())
+	assert.Equal(t, 202, strings.Count(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "/199")
+	assert.True(t, strings.HasSuffix(resp, "/last"))
+}
+
+func TestMethods(t *testing.T) {
+	s := startServer(t, keepAliveHandler)
+
+	// Test: Unknown method
+	resp := roundTrip(t, s, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 501 Not Implemented\r\n"))
+
+	// Test: Known method
+	resp = roundTrip(t, s, "DELETE /pot HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Methods limited by configuration
+	s = startServer(t, keepAliveHandler, WithMethods("GET", "HEAD", "BREW"))
+	resp = roundTrip(t, s, "DELETE /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 501 Not Implemented\r\n"))
+	resp = roundTrip(t, s, "BREW /pot HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestStrictParsing(t *testing.T) {
+	s := startServer(t, keepAliveHandler, WithStrictParsing())
+
+	// Test: Smuggling attempt is refused and the connection closed
+	resp := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /admin HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.NotContains(t, resp, "/admin")
+
+	// Test: Unsupported Transfer-Encoding
+	resp = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 501 Not Implemented\r\n"))
+}
+
+func TestLenientParsingRejectsTransferEncoding(t *testing.T) {
+	s := startServer(t, keepAliveHandler)
+
+	// Test: TE.CL smuggling attempt is refused and the connection closed
+	resp := roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n1e\r\nGET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n0\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1 "))
+	assert.NotContains(t, resp, "/admin")
+
+	// Test: Transfer-Encoding the server can't decode
+	resp = roundTrip(t, s, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 501 Not Implemented\r\n"), resp)
+	assert.NotContains(t, resp, "/admin")
+}
+
+func TestDisconnected(t *testing.T) {
+	ended := make(chan bool, 1)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		disconnected := w.Disconnected()
+		if req.RequestLine.RequestTarget == "/wait" {
+			select {
+			case <-disconnected:
+				ended <- true
+			case <-time.After(5 * time.Second):
+				ended <- false
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		keepAliveHandler(w, req)
+	})
+
+	// Test: The handler hears about the client closing the connection
+	conn := dial(t, s)
+	_, err := conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	assert.True(t, <-ended)
+
+	// Test: Requests sent while watching aren't lost
+	conn = dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = conn.Write([]byte("GET /two HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(resp), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(resp), "/two"))
+}
+
+// logRecords decodes the JSON lines of a slog.JSONHandler.
+func logRecords(t *testing.T, out *logBuffer) []map[string]any {
+	t.Helper()
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.buf.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogging(t *testing.T) {
+	out := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	handled := make(chan struct{}, 2)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		req.Log().Info("Handling")
+		keepAliveHandler(w, req)
+		handled <- struct{}{}
+	}, WithLogger(logger))
+
+	// Test: Connection events carry the connection ID and remote address
+	for _, raw := range []string{
+		"GET /a HTTP/1.1\r\nHost: localhost\r\n\r\nGET /b HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+		"GET /a HTTP/1.1\r\nHost: localhost\r\nHost: other\r\n\r\n",
+	} {
+		conn := dial(t, s)
+		_, err := conn.Write([]byte(raw))
+		require.NoError(t, err)
+		_, err = io.ReadAll(conn)
+		require.NoError(t, err)
+		conn.Close()
+		time.Sleep(50 * time.Millisecond)
+	}
+	var messages []string
+	byMessage := map[string]map[string]any{}
+	for _, record := range logRecords(t, out) {
+		msg := record["msg"].(string)
+		messages = append(messages, msg)
+		assert.Contains(t, record["remote"], "127.0.0.1:", msg)
+		byMessage[msg] = record
+	}
+	assert.Equal(t, []string{
+		"Connection opened", "Handling", "Handling", "Connection closed",
+		"Connection opened", "Request rejected", "Connection closed",
+	}, messages)
+	assert.Equal(t, float64(1), byMessage["Handling"]["conn"])
+	assert.Equal(t, float64(2), byMessage["Request rejected"]["conn"])
+	assert.Equal(t, float64(400), byMessage["Request rejected"]["status"])
+	assert.Contains(t, byMessage["Request rejected"]["err"], "Host")
+	assert.Equal(t, float64(1), byMessage["Connection closed"]["requests"])
+}
+
+// flakyListener fails to accept a few times before it is closed.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures == 0 {
+		return nil, net.ErrClosed
+	}
+	l.failures--
+	return nil, errors.New("too many open files")
+}
+
+func TestAcceptErrors(t *testing.T) {
+	out := &logBuffer{}
+	s := &Server{listener: &flakyListener{failures: 3}, logger: slog.New(slog.NewJSONHandler(out, nil))}
+
+	// Test: Accept errors are logged and retried instead of exiting
+	s.listen()
+	records := logRecords(t, out)
+	require.Len(t, records, 3)
+	for _, record := range records {
+		assert.Equal(t, "ERROR", record["level"])
+		assert.Equal(t, "too many open files", record["err"])
+	}
+	assert.Equal(t, float64(20*time.Millisecond), records[2]["retry_in"])
+}
+
+func TestHooks(t *testing.T) {
+	var mu sync.Mutex
+	var states []ConnState
+	var rejected []response.StatusCode
+	s := startServer(t, hijackHandler(t),
+		WithConnState(func(_ net.Conn, state ConnState) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, state)
+		}),
+		WithRejectHook(func(r RejectedRequest) {
+			mu.Lock()
+			defer mu.Unlock()
+			rejected = append(rejected, r.StatusCode)
+			assert.Error(t, r.Err)
+			assert.NotNil(t, r.Request)
+			assert.NotEmpty(t, r.RemoteAddr)
+		}),
+	)
+
+	// Test: Connections are reported as they open, close or are hijacked
+	for _, raw := range []string{
+		"GET /echo HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+		"GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\nquit\n",
+		"GET / HTTP/1.1\r\n\r\n",
+	} {
+		conn := dial(t, s)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Write([]byte(raw))
+		require.NoError(t, err)
+		_, err = io.ReadAll(conn)
+		require.NoError(t, err)
+		conn.Close()
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []ConnState{StateNew, StateClosed, StateNew, StateHijacked, StateNew, StateClosed}, states)
+
+	// Test: Requests the server answers itself
+	assert.Equal(t, []response.StatusCode{response.StatusBadRequest}, rejected)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is how often certificate files are checked for
+// changes when TLSOptions.ReloadInterval is zero.
+const DefaultReloadInterval = 10 * time.Second
+
+// How long a client has to finish the TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// TLSOptions configures TLS termination, see WithTLS.
+type TLSOptions struct {
+	// Certificates are the PEM certificate and key files to serve. Each
+	// handshake gets the first one valid for the server name the client
+	// asked for (SNI), or the first one if none is.
+	Certificates []CertificateFiles
+	// ClientAuth sets whether client certificates are asked for and
+	// verified, e.g. tls.RequireAndVerifyClientCert. Handlers find them
+	// in req.TLS.PeerCertificates.
+	ClientAuth tls.ClientAuthType
+	// ClientCAFile is a PEM file of the CAs client certificates are
+	// verified against. Without it the system roots are used.
+	ClientCAFile string
+	// ReloadInterval is how often the certificate files are checked for
+	// changes, so renewed certificates are served without a restart.
+	// Defaults to DefaultReloadInterval; negative turns reloading off.
+	ReloadInterval time.Duration
+}
+
+// CertificateFiles names a certificate chain and its private key.
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// WithTLS makes the server speak HTTPS instead of plain HTTP. Serve
+// fails if the certificates can't be loaded.
+func WithTLS(opts TLSOptions) Option {
+	return func(s *Server) {
+		s.tlsOptions = &opts
+	}
+}
+
+func newTLSConfig(opts TLSOptions, logger *slog.Logger) (*tls.Config, error) {
+	interval := opts.ReloadInterval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	store, err := loadCertStore(opts.Certificates, interval, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: store.getCertificate,
+		ClientAuth:     opts.ClientAuth,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+	}
+	if opts.ClientCAFile != "" {
+		pemData, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading client CAs: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("No certificates found in %s", opts.ClientCAFile)
+		}
+	}
+	return config, nil
+}
+
+// certStore holds the loaded certificates and reloads them when their
+// files change. The files are checked during handshakes, at most once
+// per interval, so an idle server doesn't poll.
+type certStore struct {
+	files    []CertificateFiles
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	certs    []*tls.Certificate
+	modTimes []time.Time
+	checked  time.Time
+}
+
+func loadCertStore(files []CertificateFiles, interval time.Duration, logger *slog.Logger) (*certStore, error) {
+	if len(files) == 0 {
+		return nil, errors.New("TLS needs at least one certificate")
+	}
+
+	store := &certStore{files: files, interval: interval, logger: logger, checked: time.Now()}
+	for _, f := range files {
+		modTime, err := certModTime(f)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading certificate %s: %w", f.CertFile, err)
+		}
+		store.certs = append(store.certs, &cert)
+		store.modTimes = append(store.modTimes, modTime)
+	}
+	return store, nil
+}
+
+func (c *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := c.current()
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
+
+// current returns the certificates, reloading those whose files have
+// changed if it's time to check.
+func (c *certStore) current() []*tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.interval < 0 || time.Since(c.checked) < c.interval {
+		return c.certs
+	}
+	c.checked = time.Now()
+
+	// Replaced rather than changed in place, as handshakes in progress
+	// may still be looking at the old slice.
+	certs := slices.Clone(c.certs)
+	for i, f := range c.files {
+		modTime, err := certModTime(f)
+		if err != nil || modTime.Equal(c.modTimes[i]) {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			// Possibly caught halfway through being replaced, so it's
+			// tried again on the next check.
+			c.logger.Warn("Error reloading certificate", "file", f.CertFile, "err", err)
+			continue
+		}
+		certs[i] = &cert
+		c.modTimes[i] = modTime
+		c.logger.Info("Reloaded certificate", "file", f.CertFile)
+	}
+	c.certs = certs
+	return certs
+}
+
+// certModTime returns when the certificate or its key last changed.
+func certModTime(f CertificateFiles) (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{f.CertFile, f.KeyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Error loading certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
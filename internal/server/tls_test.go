@@ -0,0 +1,185 @@
+package server
+
+import (
+	"app/internal/client"
+	"app/internal/devcert"
+	"app/internal/request"
+	"app/internal/response"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// certFiles generates a certificate for hosts in dir and returns its
+// files and a pool trusting it.
+func certFiles(t *testing.T, dir, name string, hosts ...string) (CertificateFiles, *x509.CertPool) {
+	t.Helper()
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, name+"-cert.pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	require.NoError(t, devcert.WriteFiles(files.CertFile, files.KeyFile, hosts, time.Hour))
+	pemData, err := os.ReadFile(files.CertFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(pemData))
+	return files, pool
+}
+
+// handshake connects to s and returns the certificate it served.
+func handshake(t *testing.T, s *Server, config *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Addr().String(), config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+var tlsInfoHandler Handler = func(w *response.Writer, req *request.Request) {
+	body := "plain"
+	if req.TLS != nil {
+		body = "tls " + req.TLS.ServerName
+		if len(req.TLS.PeerCertificates) > 0 {
+			body += " client=" + req.TLS.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	local, localPool := certFiles(t, dir, "local", "localhost")
+	other, otherPool := certFiles(t, dir, "other", "other.test")
+	s := startServer(t, tlsInfoHandler, WithTLS(TLSOptions{Certificates: []CertificateFiles{local, other}}))
+
+	// Test: Requests over TLS see the connection state
+	c := &client.Client{TLSConfig: &tls.Config{RootCAs: localPool, ServerName: "localhost"}}
+	t.Cleanup(c.CloseIdleConnections)
+	resp, err := c.Do(s.Addr().String(), proxyRequest("GET", "/", nil))
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "tls localhost", string(body))
+
+	// Test: The certificate is picked by SNI
+	cert, err := handshake(t, s, &tls.Config{RootCAs: otherPool, ServerName: "other.test"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other.test"}, cert.DNSNames)
+	cert, err = handshake(t, s, &tls.Config{RootCAs: localPool, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost"}, cert.DNSNames)
+
+	// Test: Unknown names get the first certificate
+	cert, err = handshake(t, s, &tls.Config{InsecureSkipVerify: true, ServerName: "unknown.test"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost"}, cert.DNSNames)
+
+	// Test: Plain HTTP isn't answered
+	assert.NotContains(t, roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"), "HTTP/1.1 200 OK")
+
+	// Test: Missing certificates fail Serve
+	_, err = Serve(0, tlsInfoHandler, WithTLS(TLSOptions{Certificates: []CertificateFiles{{"missing.pem", "missing-key.pem"}}}))
+	assert.Error(t, err)
+	_, err = Serve(0, tlsInfoHandler, WithTLS(TLSOptions{}))
+	assert.Error(t, err)
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverPool := certFiles(t, dir, "server", "localhost")
+	clientCert, _ := certFiles(t, dir, "client", "alice")
+	s := startServer(t, tlsInfoHandler, WithTLS(TLSOptions{
+		Certificates: []CertificateFiles{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAFile: clientCert.CertFile,
+	}))
+	get := func(certs ...tls.Certificate) (string, error) {
+		c := &client.Client{TLSConfig: &tls.Config{RootCAs: serverPool, ServerName: "localhost", Certificates: certs}}
+		defer c.CloseIdleConnections()
+		resp, err := c.Do(s.Addr().String(), proxyRequest("GET", "/", nil))
+		if err != nil {
+			return "", err
+		}
+		body, err := resp.ReadBody()
+		return string(body), err
+	}
+
+	// Test: Verified client certificates are on the request
+	pair, err := tls.LoadX509KeyPair(clientCert.CertFile, clientCert.KeyFile)
+	require.NoError(t, err)
+	body, err := get(pair)
+	require.NoError(t, err)
+	assert.Equal(t, "tls localhost client=alice", body)
+
+	// Test: Clients without a trusted certificate are turned away
+	_, err = get()
+	assert.Error(t, err)
+	stranger, _ := certFiles(t, dir, "stranger", "mallory")
+	pair, err = tls.LoadX509KeyPair(stranger.CertFile, stranger.KeyFile)
+	require.NoError(t, err)
+	_, err = get(pair)
+	assert.Error(t, err)
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	files, pool := certFiles(t, dir, "server", "localhost")
+	s := startServer(t, tlsInfoHandler, WithTLS(TLSOptions{
+		Certificates:   []CertificateFiles{files},
+		ReloadInterval: time.Millisecond,
+	}))
+	first, err := handshake(t, s, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+
+	// Test: A replaced certificate is served without a restart
+	renewed, renewedPool := certFiles(t, dir, "server", "localhost")
+	require.Equal(t, files, renewed)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, later, later))
+	time.Sleep(5 * time.Millisecond)
+	second, err := handshake(t, s, &tls.Config{RootCAs: renewedPool, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SerialNumber, second.SerialNumber)
+
+	// Test: A broken replacement keeps the current certificate
+	require.NoError(t, os.WriteFile(files.CertFile, []byte("not a certificate"), 0o644))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(files.CertFile, later, later))
+	time.Sleep(5 * time.Millisecond)
+	third, err := handshake(t, s, &tls.Config{RootCAs: renewedPool, ServerName: "localhost"})
+	require.NoError(t, err)
+	assert.Equal(t, second.SerialNumber, third.SerialNumber)
+}
+
+func TestTLSForwardedProto(t *testing.T) {
+	dir := t.TempDir()
+	files, pool := certFiles(t, dir, "proxy", "localhost")
+	backend, received := startBackend(t, tlsInfoHandler)
+	proxy, err := ReverseProxy("http://" + backend.Addr().String())
+	require.NoError(t, err)
+	s := startServer(t, proxy, WithTLS(TLSOptions{Certificates: []CertificateFiles{files}}))
+
+	// Test: Requests received over TLS are forwarded as https
+	c := &client.Client{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}
+	t.Cleanup(c.CloseIdleConnections)
+	resp, err := c.Do(s.Addr().String(), proxyRequest("GET", "/", nil))
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(body))
+	req := <-received
+	proto, _ := req.Headers.Get("X-Forwarded-Proto")
+	assert.Equal(t, "https", proto)
+	forwarded, _ := req.Headers.Get("Forwarded")
+	assert.Contains(t, forwarded, "proto=https")
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTunnelIdleTimeout is how long a tunnel may go without
+	// traffic either way when TunnelOptions.IdleTimeout is zero.
+	DefaultTunnelIdleTimeout = 5 * time.Minute
+	tunnelDialTimeout        = 10 * time.Second
+	tunnelBufferSize         = 32 << 10
+)
+
+// TunnelOptions configures Tunnel.
+type TunnelOptions struct {
+	// Allow lists the destinations tunnels may be opened to, as
+	// host:port patterns. The host may be "*" for any host or start
+	// with "*." to match subdomains, and the port may be "*". Nothing
+	// is allowed when empty, so the proxy can't be used to reach
+	// arbitrary hosts by mistake.
+	Allow []string
+	// Authenticate, when set, requires Basic credentials in
+	// Proxy-Authorization and checks them. Requests without valid ones
+	// get 407.
+	Authenticate func(user, password string) bool
+	// IdleTimeout closes a tunnel when no bytes have gone through it
+	// for this long. Defaults to DefaultTunnelIdleTimeout.
+	IdleTimeout time.Duration
+	// Dial opens the connection to a destination. A TCP dial is used
+	// when nil.
+	Dial func(address string) (net.Conn, error)
+}
+
+// Tunnel returns a handler that makes the server a forward proxy for
+// CONNECT requests (RFC 9110 section 9.3.6): it connects to the
+// host:port in the request-target, answers 200 and relays bytes both
+// ways until either side closes or the tunnel sits idle. Other methods
+// get 405.
+func Tunnel(opts TunnelOptions) Handler {
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultTunnelIdleTimeout
+	}
+	if opts.Dial == nil {
+		opts.Dial = func(address string) (net.Conn, error) {
+			return net.DialTimeout("tcp", address, tunnelDialTimeout)
+		}
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "CONNECT" {
+			rejectTunnel(w, response.StatusMethodNotAllowed, "Allow", "CONNECT", errors.New("Only CONNECT is supported"))
+			return
+		}
+		if opts.Authenticate != nil && !proxyAuthorized(req, opts.Authenticate) {
+			rejectTunnel(w, response.StatusProxyAuthRequired, "Proxy-Authenticate", `Basic realm="proxy"`, errors.New("Proxy authentication required"))
+			return
+		}
+
+		// The parser only accepts host:port targets for CONNECT.
+		destination := req.RequestLine.RequestTarget
+		if !destinationAllowed(destination, opts.Allow) {
+			writeError(w, response.StatusForbidden, fmt.Errorf("Tunnels to %s are not allowed", destination))
+			return
+		}
+
+		upstream, err := opts.Dial(destination)
+		if err != nil {
+			status := response.StatusBadGateway
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				status = response.StatusGatewayTimeout
+			}
+			writeError(w, status, fmt.Errorf("Error connecting to %s: %w", destination, err))
+			return
+		}
+
+		// The 200 is written by hand, since it must not have any framing
+		// fields and the connection isn't closed after it. It has the
+		// version the Writer would have used.
+		version := w.Version()
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			upstream.Close()
+			writeError(w, response.StatusInternalError, err)
+			return
+		}
+		_, err = fmt.Fprintf(conn, "HTTP/%s %d Connection established\r\n\r\n", version, response.StatusOK)
+		if err == nil && len(buffered) > 0 {
+			// Sent before the 200 arrived, e.g. an optimistic TLS hello.
+			_, err = upstream.Write(buffered)
+		}
+		if err != nil {
+			req.Log().Info("Error opening tunnel", "destination", destination, "err", err)
+			upstream.Close()
+			conn.Close()
+			return
+		}
+		go relay(conn, upstream, opts.IdleTimeout)
+	}
+}
+
+// rejectTunnel is writeError with a field telling the client what to
+// do instead.
+func rejectTunnel(w *response.Writer, statusCode response.StatusCode, name, value string, err error) {
+	body := fmt.Appendf(nil, "Error: %v", err)
+	h := response.GetDefaultHeaders(len(body))
+	h.Set(name, value)
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// proxyAuthorized checks Basic credentials in Proxy-Authorization.
+func proxyAuthorized(req *request.Request, authenticate func(user, password string) bool) bool {
+	value, _ := req.Headers.Get("Proxy-Authorization")
+	scheme, encoded, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, found := strings.Cut(string(decoded), ":")
+	return found && authenticate(user, password)
+}
+
+// destinationAllowed matches a host:port against the allow-list.
+func destinationAllowed(destination string, allow []string) bool {
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range allow {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil || (patternPort != "*" && patternPort != port) {
+			continue
+		}
+		patternHost = strings.ToLower(patternHost)
+		switch {
+		case patternHost == "*", patternHost == host:
+			return true
+		case strings.HasPrefix(patternHost, "*.") && strings.HasSuffix(host, patternHost[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// relay copies bytes both ways between the client and the destination.
+// When one side stops sending, that is passed on to the other and the
+// other direction carries on; both are closed once neither has sent
+// anything for idleTimeout.
+func relay(client, upstream net.Conn, idleTimeout time.Duration) {
+	idle := time.AfterFunc(idleTimeout, func() {
+		client.Close()
+		upstream.Close()
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		buf := make([]byte, tunnelBufferSize)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				idle.Reset(idleTimeout)
+				_, writeErr := dst.Write(buf[:n])
+				if writeErr != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if halfCloser, ok := dst.(interface{ CloseWrite() error }); ok {
+			halfCloser.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyHalf(upstream, client)
+	go copyHalf(client, upstream)
+	wg.Wait()
+
+	idle.Stop()
+	client.Close()
+	upstream.Close()
+}
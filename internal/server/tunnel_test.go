@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer starts a TCP server that sends back everything it
+// gets and closes its side once the client has closed its own.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// connect sends a CONNECT request, followed by extra bytes in the same
+// write, and returns the connection and the status-line.
+func connect(t *testing.T, s *Server, target string, fields string, extra string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn := dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n" + fields + "\r\n" + extra))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	for line := ""; line != "\r\n"; {
+		line, err = reader.ReadString('\n')
+		require.NoError(t, err)
+	}
+	return conn, reader, status
+}
+
+func TestTunnel(t *testing.T) {
+	echo := startEchoServer(t)
+	s := startServer(t, Tunnel(TunnelOptions{Allow: []string{"127.0.0.1:*"}}))
+
+	// Test: Bytes are relayed both ways, including ones sent early
+	conn, reader, status := connect(t, s, echo, "", "early ")
+	assert.Equal(t, "HTTP/1.1 200 Connection established\r\n", status)
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, len("early hello"))
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "early hello", string(buf))
+
+	// Test: Closing one direction is passed through
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: HTTP/1.0 clients get an HTTP/1.0 status-line
+	conn = dial(t, s)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("CONNECT " + echo + " HTTP/1.0\r\n\r\nhi"))
+	require.NoError(t, err)
+	reader = bufio.NewReader(conn)
+	status, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.0 200 Connection established\r\n", status)
+	buf = make([]byte, len("\r\nhi"))
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "\r\nhi", string(buf))
+	conn.Close()
+
+	// Test: Destinations off the allow-list are refused
+	_, _, status = connect(t, s, "localhost:"+strings.Split(echo, ":")[1], "", "")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", status)
+
+	// Test: Unreachable destinations get 502
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadAddr := dead.Addr().String()
+	dead.Close()
+	_, _, status = connect(t, s, deadAddr, "", "")
+	assert.Equal(t, "HTTP/1.1 502 Bad Gateway\r\n", status)
+
+	// Test: Other methods and targets
+	resp := roundTrip(t, s, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 405 Method Not Allowed\r\n"), resp)
+	assert.Contains(t, resp, "allow: CONNECT\r\n")
+	resp = roundTrip(t, s, "CONNECT /path HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"), resp)
+}
+
+func TestTunnelAuthentication(t *testing.T) {
+	echo := startEchoServer(t)
+	s := startServer(t, Tunnel(TunnelOptions{
+		Allow: []string{"*:*"},
+		Authenticate: func(user, password string) bool {
+			return user == "alice" && password == "s3cret"
+		},
+	}))
+	basic := func(credentials string) string {
+		return "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)) + "\r\n"
+	}
+
+	// Test: Missing or wrong credentials get 407
+	for _, fields := range []string{"", basic("alice:wrong"), basic("alice"), "Proxy-Authorization: Bearer token\r\n"} {
+		conn := dial(t, s)
+		_, err := conn.Write([]byte("CONNECT " + echo + " HTTP/1.1\r\nHost: " + echo + "\r\n" + fields + "\r\n"))
+		require.NoError(t, err)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 407 Proxy Authentication Required\r\n"), fields)
+		assert.Contains(t, string(resp), "proxy-authenticate: Basic realm=\"proxy\"\r\n")
+	}
+
+	// Test: Valid credentials open the tunnel
+	conn, reader, status := connect(t, s, echo, basic("alice:s3cret"), "ping")
+	assert.Equal(t, "HTTP/1.1 200 Connection established\r\n", status)
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	conn.Close()
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	echo := startEchoServer(t)
+	s := startServer(t, Tunnel(TunnelOptions{Allow: []string{"127.0.0.1:*"}, IdleTimeout: 50 * time.Millisecond}))
+
+	// Test: Traffic keeps the tunnel open, silence closes it
+	conn, reader, _ := connect(t, s, echo, "", "")
+	for range 4 {
+		time.Sleep(30 * time.Millisecond)
+		_, err := conn.Write([]byte("x"))
+		require.NoError(t, err)
+		b, err := reader.ReadByte()
+		require.NoError(t, err)
+		assert.Equal(t, byte('x'), b)
+	}
+	start := time.Now()
+	_, err := reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDestinationAllowed(t *testing.T) {
+	allow := []string{"example.com:443", "*.internal.test:*", "[::1]:8080"}
+	cases := map[string]bool{
+		"example.com:443":        true,
+		"EXAMPLE.com:443":        true,
+		"example.com:80":         false,
+		"www.example.com:443":    false,
+		"db.internal.test:5432":  true,
+		"a.b.internal.test:1":    true,
+		"internal.test:443":      false,
+		"evilinternal.test:443":  false,
+		"[::1]:8080":             true,
+		"[::1]:8081":             false,
+		"anything.elsewhere:443": false,
+	}
+
+	// Test: Host and port patterns
+	for destination, expected := range cases {
+		assert.Equal(t, expected, destinationAllowed(destination, allow), destination)
+	}
+	assert.False(t, destinationAllowed("example.com:443", nil))
+	assert.True(t, destinationAllowed("anywhere:1", []string{"*:*"}))
+}
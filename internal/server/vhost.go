@@ -0,0 +1,88 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// VirtualHosts picks a Handler for each request based on its Host, so
+// several sites can be served by one Server. Register every host with
+// Handle before serving, then pass vh.Dispatch to Serve.
+type VirtualHosts struct {
+	exact    map[string]Handler
+	wildcard map[string]Handler
+	// Default handles hosts that match nothing else. Without one they
+	// get 421 Misdirected Request.
+	Default Handler
+}
+
+func NewVirtualHosts(defaultHandler Handler) *VirtualHosts {
+	return &VirtualHosts{
+		exact:    map[string]Handler{},
+		wildcard: map[string]Handler{},
+		Default:  defaultHandler,
+	}
+}
+
+// Handle registers handler for a host name. A pattern like
+// "*.example.com" matches any subdomain of example.com, but not
+// example.com itself. Exact names win over wildcards, and longer
+// wildcards over shorter ones. Ports are ignored.
+func (vh *VirtualHosts) Handle(pattern string, handler Handler) {
+	pattern = normalizeHost(pattern)
+	if suffix, isWildcard := strings.CutPrefix(pattern, "*"); isWildcard {
+		vh.wildcard[suffix] = handler
+		return
+	}
+	vh.exact[pattern] = handler
+}
+
+// Dispatch is a Handler that passes the request on to the handler
+// registered for its host.
+func (vh *VirtualHosts) Dispatch(w *response.Writer, req *request.Request) {
+	host, err := req.Host()
+	if err != nil {
+		writeError(w, response.StatusBadRequest, err)
+		return
+	}
+
+	handler := vh.match(normalizeHost(host))
+	if handler == nil {
+		writeError(w, response.StatusMisdirectedRequest, fmt.Errorf("No site is configured for host %q", host))
+		return
+	}
+	handler(w, req)
+}
+
+func (vh *VirtualHosts) match(host string) Handler {
+	if handler, ok := vh.exact[host]; ok {
+		return handler
+	}
+
+	var best Handler
+	bestLen := 0
+	for suffix, handler := range vh.wildcard {
+		if len(suffix) > bestLen && len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+			best = handler
+			bestLen = len(suffix)
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	return vh.Default
+}
+
+// normalizeHost lowercases a host and strips its port and any trailing
+// dot, so "Example.COM.:8080" and "example.com" are the same site.
+func normalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.ToLower(host)
+}
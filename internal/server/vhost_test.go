@@ -0,0 +1,56 @@
+package server
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func siteHandler(name string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		body := []byte(name)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	vh := NewVirtualHosts(nil)
+	vh.Handle("example.com", siteHandler("apex"))
+	vh.Handle("*.example.com", siteHandler("wildcard"))
+	vh.Handle("*.api.example.com", siteHandler("api"))
+	vh.Handle("static.example.com", siteHandler("static"))
+	s := startServer(t, vh.Dispatch)
+
+	get := func(host string) string {
+		return roundTrip(t, s, "GET / HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	}
+
+	// Test: Exact match, ignoring case, port and trailing dot
+	assert.True(t, strings.HasSuffix(get("example.com"), "apex"))
+	assert.True(t, strings.HasSuffix(get("EXAMPLE.com.:42069"), "apex"))
+	assert.True(t, strings.HasSuffix(get("static.example.com"), "static"))
+
+	// Test: Wildcard subdomains, most specific first
+	assert.True(t, strings.HasSuffix(get("blog.example.com"), "wildcard"))
+	assert.True(t, strings.HasSuffix(get("a.b.example.com"), "wildcard"))
+	assert.True(t, strings.HasSuffix(get("v1.api.example.com"), "api"))
+
+	// Test: Unknown host without a default
+	assert.True(t, strings.HasPrefix(get("other.org"), "HTTP/1.1 421 Misdirected Request\r\n"))
+	assert.True(t, strings.HasPrefix(get("notexample.com"), "HTTP/1.1 421 Misdirected Request\r\n"))
+
+	// Test: Default handler
+	vh.Default = siteHandler("default")
+	assert.True(t, strings.HasSuffix(get("other.org"), "default"))
+
+	// Test: Missing or repeated Host is rejected by the server
+	resp := roundTrip(t, s, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+	resp = roundTrip(t, s, "GET / HTTP/1.1\r\nHost: example.com\r\nHost: other.org\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const watchBufferSize = 4096
+
+// connWatcher notices a client closing its connection while the
+// handler is still running, by reading from the connection in the
+// background. A client that sends something instead, such as a
+// pipelined request, is still there: watching stops and the bytes are
+// kept for the next request.
+type connWatcher struct {
+	conn net.Conn
+	gone chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	// Closed when the background read has returned.
+	done     chan struct{}
+	stopping atomic.Bool
+	read     []byte
+}
+
+func newConnWatcher(conn net.Conn) *connWatcher {
+	return &connWatcher{conn: conn, gone: make(chan struct{})}
+}
+
+// start begins watching unless it has already begun or been stopped,
+// and returns the channel closed when the client is gone.
+func (c *connWatcher) start() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done == nil && !c.stopped {
+		c.done = make(chan struct{})
+		go c.watch()
+	}
+	return c.gone
+}
+
+func (c *connWatcher) watch() {
+	defer close(c.done)
+	buf := make([]byte, watchBufferSize)
+	n, err := c.conn.Read(buf)
+	c.read = buf[:n]
+	if err != nil && !c.stopping.Load() {
+		close(c.gone)
+	}
+}
+
+// stop ends watching, interrupting the background read, and returns the
+// bytes it read. The connection is left as it was found.
+func (c *connWatcher) stop() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	c.stopped = true
+	if c.done == nil {
+		return nil
+	}
+	c.stopping.Store(true)
+	c.conn.SetReadDeadline(time.Unix(1, 0))
+	<-c.done
+	c.conn.SetReadDeadline(time.Time{})
+	return c.read
+}
+
+// isGone reports whether the client closed the connection while being
+// watched.
+func (c *connWatcher) isGone() bool {
+	select {
+	case <-c.gone:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,264 @@
+// Package sse streams Server-Sent Events (the text/event-stream format
+// from the HTML Living Standard) over chunked responses.
+package sse
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how long a stream may go without sending
+// anything before a comment is sent to keep it open, when
+// Options.HeartbeatInterval is zero.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// ErrClosed is returned for sends on a stream that is done, because it
+// was closed or the client went away.
+var ErrClosed = errors.New("Event stream closed")
+
+// Event is a single event. Only Data is required; a zero Retry leaves
+// the client's reconnection delay alone.
+type Event struct {
+	// Event is the event type, "message" on the client when empty.
+	Event string
+	// ID is sent back by the client in Last-Event-ID when it reconnects.
+	ID   string
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Options configures StartWithOptions.
+type Options struct {
+	// HeartbeatInterval is how often a comment is sent while no events
+	// are, so proxies and clients don't time the stream out. Defaults to
+	// DefaultHeartbeatInterval; negative disables heartbeats.
+	HeartbeatInterval time.Duration
+}
+
+// Stream is an event stream started by Start. Its methods may be called
+// from several goroutines, but the handler must not return before the
+// stream is done, since heartbeats keep writing to the response.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu        sync.Mutex
+	err       error
+	lastWrite time.Time
+	// Closed once the stream is done, see Done.
+	done      chan struct{}
+	closeOnce sync.Once
+	// Closed by Close to stop the heartbeat.
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// LastEventID returns the ID of the last event a reconnecting client
+// received, or "" for a new client.
+func LastEventID(req *request.Request) string {
+	id, _ := req.Headers.Get("Last-Event-ID")
+	return id
+}
+
+// Start answers req with an event stream: it writes a 200 status-line
+// and chunked headers with Content-Type text/event-stream and
+// Cache-Control no-cache, and returns the Stream to send events on,
+// with heartbeats every DefaultHeartbeatInterval. It fails if w has
+// already started a response or was hijacked. From then on the Stream
+// owns w, so the caller must not write to it directly, and should call
+// Close before returning. See StartWithOptions.
+func Start(w *response.Writer, req *request.Request) (*Stream, error) {
+	return StartWithOptions(w, req, Options{})
+}
+
+// StartWithOptions writes the status-line and headers of an event
+// stream, and returns the Stream to send events on. Each event is sent
+// straight away as a chunk of its own. The stream is done when the
+// client disconnects or Close is called. HEAD requests only get the
+// headers, and the returned stream is already done.
+func StartWithOptions(w *response.Writer, req *request.Request, opts Options) (*Stream, error) {
+	interval := opts.HeartbeatInterval
+	if interval == 0 {
+		interval = DefaultHeartbeatInterval
+	}
+
+	h := response.GetChunkedHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	err := w.WriteStatusLine(response.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:           w,
+		lastEventID: LastEventID(req),
+		lastWrite:   time.Now(),
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	if req.RequestLine.Method == "HEAD" {
+		s.err = ErrClosed
+		s.finish()
+		close(s.done)
+		return s, nil
+	}
+
+	s.wg.Add(1)
+	go s.watch(w.Disconnected(), interval)
+	return s, nil
+}
+
+// LastEventID returns the Last-Event-ID of the request, see the
+// function of the same name.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done returns a channel that is closed once the stream is done, so
+// handlers can stop producing events.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send writes an event. Multi-line data is split into one data field
+// per line.
+func (s *Stream) Send(ev Event) error {
+	if strings.ContainsAny(ev.Event, "\r\n") {
+		return fmt.Errorf("Invalid event type: %q", ev.Event)
+	}
+	if strings.ContainsAny(ev.ID, "\r\n\x00") {
+		return fmt.Errorf("Invalid event ID: %q", ev.ID)
+	}
+	if ev.Retry < 0 {
+		return fmt.Errorf("Invalid retry delay: %v", ev.Retry)
+	}
+
+	var sb strings.Builder
+	if ev.Event != "" {
+		sb.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.ID != "" {
+		sb.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Retry > 0 {
+		sb.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range splitLines(ev.Data) {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteByte('\n')
+	return s.write(sb.String())
+}
+
+// Comment writes a comment, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var sb strings.Builder
+	for _, line := range splitLines(text) {
+		sb.WriteString(": " + line + "\n")
+	}
+	sb.WriteByte('\n')
+	return s.write(sb.String())
+}
+
+// Close ends the stream. It doesn't need to be called when the client
+// has gone away already.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.err = ErrClosed
+	err := s.finish()
+	s.mu.Unlock()
+
+	s.end()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Stream) write(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	_, err := s.w.WriteChunkedBody([]byte(text))
+	if err != nil {
+		s.err = fmt.Errorf("Error writing event: %w", err)
+		s.end()
+		return s.err
+	}
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// finish writes the end of the chunked body.
+func (s *Stream) finish() error {
+	_, err := s.w.WriteChunkedBodyDone()
+	if err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(nil)
+}
+
+// end marks the stream done and stops the heartbeat.
+func (s *Stream) end() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		close(s.stop)
+	})
+}
+
+// watch sends heartbeats while the stream is quiet and ends it when the
+// client disconnects.
+func (s *Stream) watch(disconnected <-chan struct{}, interval time.Duration) {
+	defer s.wg.Done()
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-disconnected:
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = ErrClosed
+			}
+			s.mu.Unlock()
+			s.end()
+			return
+		case <-tick:
+			s.mu.Lock()
+			quiet := time.Since(s.lastWrite) >= interval
+			s.mu.Unlock()
+			if quiet {
+				s.Comment("heartbeat")
+			}
+		}
+	}
+}
+
+// splitLines splits text at CRLF, CR or LF, which all end a line in an
+// event stream.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Split(text, "\n")
+}
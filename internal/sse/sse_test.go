@@ -0,0 +1,142 @@
+package sse
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"app/internal/server"
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startStream serves event streams, handing each one to handle.
+func startStream(t *testing.T, opts Options, handle func(s *Stream)) *server.Server {
+	t.Helper()
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		stream, err := StartWithOptions(w, req, opts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		handle(stream)
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	head   string
+}
+
+func get(t *testing.T, s *server.Server, method string, fields string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte(method + " /events HTTP/1.1\r\nHost: localhost\r\n" + fields + "\r\n"))
+	require.NoError(t, err)
+
+	c := &testClient{conn: conn, reader: bufio.NewReader(conn)}
+	for {
+		line, err := c.reader.ReadString('\n')
+		require.NoError(t, err)
+		c.head += line
+		if line == "\r\n" {
+			return c
+		}
+	}
+}
+
+// chunk reads a single chunk of the body, "" for the last one.
+func (c *testClient) chunk(t *testing.T) string {
+	t.Helper()
+	line, err := c.reader.ReadString('\n')
+	require.NoError(t, err)
+	size, err := strconv.ParseInt(strings.TrimSuffix(line, "\r\n"), 16, 64)
+	require.NoError(t, err)
+	data := make([]byte, size+2)
+	_, err = io.ReadFull(c.reader, data)
+	require.NoError(t, err)
+	return string(data[:size])
+}
+
+func TestStream(t *testing.T) {
+	next := make(chan struct{})
+	s := startStream(t, Options{HeartbeatInterval: -1}, func(stream *Stream) {
+		assert.NoError(t, stream.Send(Event{Data: "id " + stream.LastEventID()}))
+		<-next
+		assert.NoError(t, stream.Send(Event{Event: "update", ID: "7", Retry: 2 * time.Second, Data: "one\ntwo\r\nthree\rfour"}))
+		assert.NoError(t, stream.Comment("just\nsaying"))
+		assert.Error(t, stream.Send(Event{Event: "bad\ntype", Data: "x"}))
+		assert.Error(t, stream.Send(Event{ID: "bad\x00id", Data: "x"}))
+		assert.NoError(t, stream.Close())
+		assert.ErrorIs(t, stream.Send(Event{Data: "late"}), ErrClosed)
+		<-stream.Done()
+	})
+
+	// Test: Headers, and each event is sent straight away
+	c := get(t, s, "GET", "Last-Event-ID: 42\r\n")
+	assert.True(t, strings.HasPrefix(c.head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, c.head, "content-type: text/event-stream\r\n")
+	assert.Contains(t, c.head, "cache-control: no-cache\r\n")
+	assert.Contains(t, c.head, "transfer-encoding: chunked\r\n")
+	assert.Equal(t, "data: id 42\n\n", c.chunk(t))
+	close(next)
+
+	// Test: Fields, line splitting and comments
+	assert.Equal(t, "event: update\nid: 7\nretry: 2000\ndata: one\ndata: two\ndata: three\ndata: four\n\n", c.chunk(t))
+	assert.Equal(t, ": just\n: saying\n\n", c.chunk(t))
+
+	// Test: Close ends the body
+	assert.Equal(t, "", c.chunk(t))
+}
+
+func TestHeartbeat(t *testing.T) {
+	s := startStream(t, Options{HeartbeatInterval: 20 * time.Millisecond}, func(stream *Stream) {
+		<-stream.Done()
+	})
+
+	// Test: Quiet streams get comments
+	c := get(t, s, "GET", "")
+	for range 2 {
+		assert.Equal(t, ": heartbeat\n\n", c.chunk(t))
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	ended := make(chan error, 1)
+	s := startStream(t, Options{}, func(stream *Stream) {
+		select {
+		case <-stream.Done():
+			ended <- stream.Send(Event{Data: "anyone?"})
+		case <-time.After(5 * time.Second):
+			ended <- nil
+		}
+	})
+
+	// Test: The stream is done once the client goes away
+	c := get(t, s, "GET", "")
+	c.conn.Close()
+	assert.ErrorIs(t, <-ended, ErrClosed)
+
+	// Test: HEAD gets the headers and nothing else
+	s = startStream(t, Options{}, func(stream *Stream) {
+		<-stream.Done()
+		assert.NoError(t, stream.Close())
+	})
+	c = get(t, s, "HEAD", "")
+	assert.Contains(t, c.head, "content-type: text/event-stream\r\n")
+	rest, err := io.ReadAll(c.reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
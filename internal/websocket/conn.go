@@ -0,0 +1,378 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the kind of data a message carries.
+type MessageType int
+
+const (
+	TextMessage   = MessageType(opText)
+	BinaryMessage = MessageType(opBinary)
+)
+
+// Close status codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	// CloseNoStatus is reported for close frames without a code. It is
+	// never sent.
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// How long Close waits for the client to answer its close frame.
+const closeTimeout = 5 * time.Second
+
+var (
+	// ErrProtocol is returned by ReadMessage when the client breaks the
+	// protocol. The connection has been closed with CloseProtocolError.
+	ErrProtocol = errors.New("WebSocket protocol error")
+	// ErrMessageTooBig is returned by ReadMessage for messages over the
+	// maximum size. The connection has been closed with
+	// CloseMessageTooBig.
+	ErrMessageTooBig = errors.New("WebSocket message too big")
+	// ErrInvalidUTF8 is returned by ReadMessage for text that isn't
+	// UTF-8. The connection has been closed with CloseInvalidPayload.
+	ErrInvalidUTF8 = errors.New("WebSocket text is not valid UTF-8")
+	// ErrCloseSent is returned for writes after the close frame.
+	ErrCloseSent = errors.New("WebSocket close frame already sent")
+)
+
+// CloseError is returned by ReadMessage once the client has closed the
+// connection, with the code and reason it gave.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("WebSocket closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("WebSocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection made by Upgrade. One goroutine may
+// read messages while others write: writes are serialized, and pings
+// are answered from ReadMessage.
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	subprotocol    string
+	maxMessageSize int64
+	fragmentSize   int
+
+	readMu  sync.Mutex
+	readErr error
+	// Closed once reading stops with readErr.
+	readDone chan struct{}
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, subprotocol string, opts Options) *Conn {
+	maxMessageSize := opts.MaxMessageSize
+	if maxMessageSize == 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		subprotocol:    subprotocol,
+		maxMessageSize: maxMessageSize,
+		fragmentSize:   opts.FragmentSize,
+		readDone:       make(chan struct{}),
+	}
+}
+
+// Subprotocol returns the subprotocol chosen in the handshake, or "" if
+// there is none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// NetConn returns the underlying connection, e.g. to set deadlines.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage reads the next text or binary message, joining fragments.
+// Pings are answered and pongs skipped along the way. When the client
+// closes the connection, ReadMessage answers and returns a *CloseError;
+// after any error it keeps returning the same one.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	msgType, message, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		close(c.readDone)
+	}
+	return msgType, message, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var msgType byte
+	var message []byte
+	for {
+		h, err := readFrameHeader(c.reader)
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				return 0, nil, c.fail(CloseProtocolError, err)
+			}
+			return 0, nil, err
+		}
+		err = checkFrame(h, msgType != 0)
+		if err != nil {
+			return 0, nil, c.fail(CloseProtocolError, err)
+		}
+		if !isControl(h.opcode) && h.length > uint64(c.maxMessageSize-int64(len(message))) {
+			return 0, nil, c.fail(CloseMessageTooBig, ErrMessageTooBig)
+		}
+
+		payload := make([]byte, h.length)
+		_, err = io.ReadFull(c.reader, payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		maskBytes(h.mask, payload)
+
+		switch h.opcode {
+		case opPing:
+			err = c.writeFrame(opPong, payload)
+			if err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.closeReceived(payload)
+		case opText, opBinary:
+			msgType = h.opcode
+		}
+
+		message = append(message, payload...)
+		if h.fin {
+			if msgType == opText && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidPayload, ErrInvalidUTF8)
+			}
+			return MessageType(msgType), message, nil
+		}
+	}
+}
+
+// checkFrame enforces the framing rules for frames from a client.
+func checkFrame(h frameHeader, fragmented bool) error {
+	switch {
+	case h.rsv != 0:
+		return fmt.Errorf("%w: reserved bits set without an extension", ErrProtocol)
+	case !h.masked:
+		return fmt.Errorf("%w: client frames must be masked", ErrProtocol)
+	case isControl(h.opcode):
+		if h.opcode != opClose && h.opcode != opPing && h.opcode != opPong {
+			return fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, h.opcode)
+		}
+		if !h.fin || h.length > maxControlPayload {
+			return fmt.Errorf("%w: control frames can't be fragmented or over %d bytes", ErrProtocol, maxControlPayload)
+		}
+	case h.opcode == opContinuation:
+		if !fragmented {
+			return fmt.Errorf("%w: continuation frame without a message to continue", ErrProtocol)
+		}
+	case h.opcode == opText || h.opcode == opBinary:
+		if fragmented {
+			return fmt.Errorf("%w: new message before the last one was finished", ErrProtocol)
+		}
+	default:
+		return fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, h.opcode)
+	}
+	return nil
+}
+
+// closeReceived answers the client's close frame with the same code,
+// closes the connection and returns the *CloseError for it.
+func (c *Conn) closeReceived(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) > 0 {
+		if len(payload) == 1 {
+			return c.fail(CloseProtocolError, fmt.Errorf("%w: close frame with a 1 byte payload", ErrProtocol))
+		}
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, fmt.Errorf("%w: invalid close code %d", ErrProtocol, closeErr.Code))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, ErrInvalidUTF8)
+		}
+	}
+	var err error
+	if closeErr.Code == CloseNoStatus {
+		err = c.writeClose(nil)
+	} else {
+		err = c.writeClose(binary.BigEndian.AppendUint16(nil, uint16(closeErr.Code)))
+	}
+	if err != nil && !errors.Is(err, ErrCloseSent) {
+		c.conn.Close()
+		return err
+	}
+	c.conn.Close()
+	return closeErr
+}
+
+// validCloseCode reports whether code may be sent in a close frame:
+// one defined by RFC 6455 or the IANA registry, or one for libraries
+// and applications (3000-4999).
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail closes the connection with code because the client broke the
+// protocol, and returns err.
+func (c *Conn) fail(code int, err error) error {
+	c.writeClose(closePayload(code, ""))
+	c.conn.Close()
+	return err
+}
+
+// WriteMessage sends data as a text or binary message, split into
+// frames of Options.FragmentSize if set. Text must be valid UTF-8.
+func (c *Conn) WriteMessage(msgType MessageType, data []byte) error {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return fmt.Errorf("Invalid WebSocket message type %d", msgType)
+	}
+	if msgType == TextMessage && !utf8.Valid(data) {
+		return ErrInvalidUTF8
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	opcode := byte(msgType)
+	var buf []byte
+	for {
+		fragment := data
+		if c.fragmentSize > 0 && len(fragment) > c.fragmentSize {
+			fragment = data[:c.fragmentSize]
+		}
+		data = data[len(fragment):]
+		buf = appendFrame(buf, len(data) == 0, opcode, fragment, nil)
+		opcode = opContinuation
+		if len(data) == 0 {
+			break
+		}
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Ping sends a ping with up to 125 bytes of data. The client answers
+// with a pong, which ReadMessage skips.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("Ping data over %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(opPing, data)
+}
+
+// Close starts the closing handshake with code and reason, then closes
+// the connection once the client answers or after a timeout. When
+// another goroutine is in ReadMessage, that call returns the client's
+// answer as a *CloseError; otherwise Close reads and drops whatever
+// arrives before it.
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		return fmt.Errorf("Close reason over %d bytes", maxControlPayload-2)
+	}
+	err := c.writeClose(closePayload(code, reason))
+	if errors.Is(err, ErrCloseSent) {
+		return c.closeConn()
+	}
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if c.readMu.TryLock() {
+		// Nobody is reading, so wait for the answer here.
+		c.readMu.Unlock()
+		for {
+			_, _, err := c.ReadMessage()
+			if err != nil {
+				break
+			}
+		}
+	} else {
+		select {
+		case <-c.readDone:
+		case <-time.After(closeTimeout):
+		}
+	}
+	return c.closeConn()
+}
+
+// closeConn closes the connection, which the closing handshake may have
+// done already.
+func (c *Conn) closeConn() error {
+	err := c.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// writeClose sends a close frame, unless one has been sent already.
+func (c *Conn) writeClose(payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	c.closeSent = true
+	_, err := c.conn.Write(appendFrame(nil, true, opClose, payload, nil))
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	_, err := c.conn.Write(appendFrame(nil, true, opcode, payload, nil))
+	return err
+}
@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Frame opcodes (RFC 6455 section 5.2).
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+// Control frames can't be fragmented and carry at most this much.
+const maxControlPayload = 125
+
+const (
+	finBit  = 0x80
+	rsvBits = 0x70
+	maskBit = 0x80
+)
+
+type frameHeader struct {
+	fin    bool
+	rsv    byte
+	opcode byte
+	masked bool
+	mask   [4]byte
+	length uint64
+}
+
+func isControl(opcode byte) bool {
+	return opcode&0x8 != 0
+}
+
+// readFrameHeader reads the fixed part of a frame, the extended payload
+// length and the masking key.
+func readFrameHeader(r io.Reader) (frameHeader, error) {
+	var buf [8]byte
+	_, err := io.ReadFull(r, buf[:2])
+	if err != nil {
+		return frameHeader{}, err
+	}
+
+	h := frameHeader{
+		fin:    buf[0]&finBit != 0,
+		rsv:    buf[0] & rsvBits,
+		opcode: buf[0] & 0x0F,
+		masked: buf[1]&maskBit != 0,
+		length: uint64(buf[1] & 0x7F),
+	}
+
+	switch h.length {
+	case 126:
+		_, err = io.ReadFull(r, buf[:2])
+		if err != nil {
+			return h, err
+		}
+		h.length = uint64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		_, err = io.ReadFull(r, buf[:8])
+		if err != nil {
+			return h, err
+		}
+		h.length = binary.BigEndian.Uint64(buf[:8])
+		if h.length>>63 != 0 {
+			return h, fmt.Errorf("%w: payload length has its most significant bit set", ErrProtocol)
+		}
+	}
+
+	if h.masked {
+		_, err = io.ReadFull(r, h.mask[:])
+		if err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// appendFrame appends a frame holding payload to buf, masked with mask
+// unless it is nil. Servers send their frames unmasked.
+func appendFrame(buf []byte, fin bool, opcode byte, payload []byte, mask *[4]byte) []byte {
+	first := opcode
+	if fin {
+		first |= finBit
+	}
+	buf = append(buf, first)
+
+	var maskFlag byte
+	if mask != nil {
+		maskFlag = maskBit
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		buf = append(buf, maskFlag|byte(length))
+	case length <= 0xFFFF:
+		buf = append(buf, maskFlag|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, maskFlag|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if mask == nil {
+		return append(buf, payload...)
+	}
+	buf = append(buf, mask[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	maskBytes(*mask, buf[start:])
+	return buf
+}
+
+// maskBytes XORs a whole payload with the masking key. Masking and
+// unmasking are the same operation.
+func maskBytes(mask [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= mask[i&3]
+	}
+}
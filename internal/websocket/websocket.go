@@ -0,0 +1,169 @@
+// Package websocket implements the server side of the WebSocket
+// protocol (RFC 6455) on top of the server package: the opening
+// handshake from a handler, and messages on the hijacked connection.
+package websocket
+
+import (
+	"app/internal/headers"
+	"app/internal/request"
+	"app/internal/response"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept (RFC
+// 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The only protocol version there is.
+const version = "13"
+
+// DefaultMaxMessageSize is the largest message a Conn reads when
+// Options.MaxMessageSize is zero.
+const DefaultMaxMessageSize = 1 << 20
+
+// ErrBadHandshake is returned by Upgrade for requests that aren't a
+// valid opening handshake. The client has been sent an error response.
+var ErrBadHandshake = errors.New("Bad WebSocket handshake")
+
+// Options configures UpgradeWithOptions.
+type Options struct {
+	// Subprotocols the server speaks, in order of preference. The first
+	// one the client also offers is chosen, see Conn.Subprotocol.
+	Subprotocols []string
+	// CheckOrigin decides whether to accept a handshake by its Origin
+	// field. Browsers allow any page to open WebSockets to any server,
+	// so servers relying on cookies should check it. Every origin is
+	// accepted when nil.
+	CheckOrigin func(req *request.Request) bool
+	// MaxMessageSize limits the size of messages read, after joining
+	// fragments. Larger ones close the connection with
+	// CloseMessageTooBig. Defaults to DefaultMaxMessageSize.
+	MaxMessageSize int64
+	// FragmentSize splits messages written into frames of at most this
+	// many bytes. Zero sends every message as a single frame.
+	FragmentSize int
+}
+
+// Upgrade performs the opening handshake for req and takes over the
+// connection. On failure the client is sent an error response, so the
+// handler just returns.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	return UpgradeWithOptions(w, req, Options{})
+}
+
+// UpgradeWithOptions is Upgrade with control over subprotocols, origin
+// checks and message sizes.
+func UpgradeWithOptions(w *response.Writer, req *request.Request, opts Options) (*Conn, error) {
+	key, status, err := checkHandshake(req)
+	if err != nil {
+		h := headers.Headers{}
+		if status == response.StatusUpgradeRequired {
+			h.Set("Sec-WebSocket-Version", version)
+			h.Set("Upgrade", "websocket")
+		}
+		writeError(w, status, h, err)
+		return nil, fmt.Errorf("%w: %v", ErrBadHandshake, err)
+	}
+	if opts.CheckOrigin != nil && !opts.CheckOrigin(req) {
+		err := errors.New("Origin not allowed")
+		writeError(w, response.StatusForbidden, headers.Headers{}, err)
+		return nil, fmt.Errorf("%w: %v", ErrBadHandshake, err)
+	}
+
+	h := headers.Headers{}
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	subprotocol := chooseSubprotocol(req, opts.Subprotocols)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	err = w.WriteInterim(response.StatusSwitchingProtocols, h)
+	if err != nil {
+		return nil, fmt.Errorf("Error sending handshake response: %w", err)
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader = conn
+	if len(buffered) > 0 {
+		// The client may send frames right behind the handshake.
+		reader = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	return newConn(conn, bufio.NewReader(reader), subprotocol, opts), nil
+}
+
+// checkHandshake validates the opening handshake (RFC 6455 section
+// 4.2.1) and returns the client's key, or the status to reject it with.
+func checkHandshake(req *request.Request) (string, response.StatusCode, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", response.StatusMethodNotAllowed, errors.New("WebSocket handshake must use GET")
+	}
+	if req.RequestLine.HttpVersion != "1.1" {
+		return "", response.StatusBadRequest, errors.New("WebSocket handshake needs HTTP/1.1")
+	}
+	upgrade, _ := req.Headers.Get("Upgrade")
+	if !hasToken(upgrade, "websocket") {
+		return "", response.StatusUpgradeRequired, errors.New("Upgrade must include websocket")
+	}
+	connection, _ := req.Headers.Get("Connection")
+	if !hasToken(connection, "upgrade") {
+		return "", response.StatusBadRequest, errors.New("Connection must include upgrade")
+	}
+	if v, _ := req.Headers.Get("Sec-WebSocket-Version"); v != version {
+		return "", response.StatusUpgradeRequired, fmt.Errorf("Unsupported WebSocket version %q", v)
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", response.StatusBadRequest, errors.New("Sec-WebSocket-Key must be 16 bytes in base64")
+	}
+	return key, 0, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// chooseSubprotocol picks the first of the server's subprotocols the
+// client offered.
+func chooseSubprotocol(req *request.Request, supported []string) string {
+	offered, _ := req.Headers.Get("Sec-WebSocket-Protocol")
+	for _, protocol := range supported {
+		if hasToken(offered, protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// hasToken reports whether a comma-separated field value contains token,
+// ignoring case.
+func hasToken(value, token string) bool {
+	for _, item := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w *response.Writer, status response.StatusCode, h headers.Headers, err error) {
+	body := []byte(err.Error())
+	for name, value := range response.GetDefaultHeaders(len(body)) {
+		h.Set(name, value)
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
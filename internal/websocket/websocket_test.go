@@ -0,0 +1,302 @@
+package websocket
+
+import (
+	"app/internal/request"
+	"app/internal/response"
+	"app/internal/server"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The sample key and accept value from RFC 6455 section 1.3.
+const (
+	sampleKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	sampleAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+var clientMask = [4]byte{0x37, 0xfa, 0x21, 0x3d}
+
+// startEcho serves WebSockets that echo every message and reports how
+// each connection ended.
+func startEcho(t *testing.T, opts Options) (*server.Server, chan error) {
+	t.Helper()
+	ended := make(chan error, 10)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := UpgradeWithOptions(w, req, opts)
+		if err != nil {
+			ended <- err
+			return
+		}
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				ended <- err
+				return
+			}
+			if bytes.Equal(data, []byte("close please")) {
+				ended <- conn.Close(CloseGoingAway, "bye")
+				return
+			}
+			err = conn.WriteMessage(msgType, data)
+			if err != nil {
+				ended <- err
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, ended
+}
+
+func handshakeRequest(fields map[string]string) string {
+	h := map[string]string{
+		"Host":                  "localhost",
+		"Upgrade":               "websocket",
+		"Connection":            "keep-alive, Upgrade",
+		"Sec-WebSocket-Key":     sampleKey,
+		"Sec-WebSocket-Version": "13",
+	}
+	for name, value := range fields {
+		h[name] = value
+	}
+	raw := "GET /chat HTTP/1.1\r\n"
+	for name, value := range h {
+		if value != "" {
+			raw += name + ": " + value + "\r\n"
+		}
+	}
+	return raw + "\r\n"
+}
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	status string
+	fields map[string]string
+}
+
+// dial sends a handshake, followed by extra bytes in the same write,
+// and reads the response head.
+func dial(t *testing.T, s *server.Server, handshake string, extra []byte) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(append([]byte(handshake), extra...))
+	require.NoError(t, err)
+
+	c := &testClient{conn: conn, reader: bufio.NewReader(conn), fields: map[string]string{}}
+	c.status, err = c.reader.ReadString('\n')
+	require.NoError(t, err)
+	for {
+		line, err := c.reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		c.fields[strings.ToLower(name)] = value
+	}
+	return c
+}
+
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	return appendFrame(nil, fin, opcode, payload, &clientMask)
+}
+
+func (c *testClient) send(t *testing.T, frames ...[]byte) {
+	t.Helper()
+	_, err := c.conn.Write(bytes.Join(frames, nil))
+	require.NoError(t, err)
+}
+
+func (c *testClient) read(t *testing.T) (frameHeader, []byte) {
+	t.Helper()
+	h, err := readFrameHeader(c.reader)
+	require.NoError(t, err)
+	assert.False(t, h.masked, "server frames are unmasked")
+	payload := make([]byte, h.length)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(t, err)
+	return h, payload
+}
+
+// readClose reads a close frame and returns its code.
+func (c *testClient) readClose(t *testing.T) int {
+	t.Helper()
+	h, payload := c.read(t)
+	require.Equal(t, opClose, h.opcode)
+	require.GreaterOrEqual(t, len(payload), 2)
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func TestHandshake(t *testing.T) {
+	s, ended := startEcho(t, Options{
+		Subprotocols: []string{"chat.v2", "chat.v1"},
+		CheckOrigin: func(req *request.Request) bool {
+			origin, _ := req.Headers.Get("Origin")
+			return origin != "https://evil.example"
+		},
+	})
+
+	// Test: A valid handshake switches protocols
+	c := dial(t, s, handshakeRequest(map[string]string{"Sec-WebSocket-Protocol": "chat.v1, chat.v2"}), nil)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", c.status)
+	assert.Equal(t, sampleAccept, c.fields["sec-websocket-accept"])
+	assert.Equal(t, "websocket", c.fields["upgrade"])
+	assert.Equal(t, "Upgrade", c.fields["connection"])
+	assert.Equal(t, "chat.v2", c.fields["sec-websocket-protocol"])
+
+	// Test: No subprotocol when none is shared
+	c = dial(t, s, handshakeRequest(map[string]string{"Sec-WebSocket-Protocol": "other"}), nil)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", c.status)
+	assert.NotContains(t, c.fields, "sec-websocket-protocol")
+
+	// Test: Invalid handshakes are rejected
+	cases := []struct {
+		handshake string
+		status    string
+	}{
+		{handshakeRequest(map[string]string{"Upgrade": ""}), "426"},
+		{handshakeRequest(map[string]string{"Sec-WebSocket-Version": "8"}), "426"},
+		{handshakeRequest(map[string]string{"Connection": "keep-alive"}), "400"},
+		{handshakeRequest(map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}), "400"},
+		{handshakeRequest(map[string]string{"Sec-WebSocket-Key": ""}), "400"},
+		{strings.Replace(handshakeRequest(nil), "GET", "POST", 1), "405"},
+		{handshakeRequest(map[string]string{"Origin": "https://evil.example"}), "403"},
+	}
+	for _, tc := range cases {
+		c = dial(t, s, tc.handshake, nil)
+		assert.True(t, strings.HasPrefix(c.status, "HTTP/1.1 "+tc.status), c.status)
+		err := <-ended
+		assert.ErrorIs(t, err, ErrBadHandshake)
+	}
+	c = dial(t, s, handshakeRequest(map[string]string{"Sec-WebSocket-Version": "8"}), nil)
+	assert.Equal(t, "13", c.fields["sec-websocket-version"])
+}
+
+func TestMessages(t *testing.T) {
+	s, _ := startEcho(t, Options{})
+
+	// Test: Frames sent along with the handshake aren't lost
+	c := dial(t, s, handshakeRequest(nil), clientFrame(true, opText, []byte("early")))
+	h, payload := c.read(t)
+	assert.Equal(t, opText, h.opcode)
+	assert.True(t, h.fin)
+	assert.Equal(t, "early", string(payload))
+
+	// Test: Binary messages, including ones with 16 and 64 bit lengths
+	for _, size := range []int{0, 125, 126, 70000} {
+		data := bytes.Repeat([]byte{0xff}, size)
+		c.send(t, clientFrame(true, opBinary, data))
+		h, payload = c.read(t)
+		assert.Equal(t, opBinary, h.opcode)
+		assert.Equal(t, data, payload, size)
+	}
+
+	// Test: Fragments are joined, with pings answered in between
+	c.send(t,
+		clientFrame(false, opText, []byte("frag")),
+		clientFrame(true, opPing, []byte("are you there")),
+		clientFrame(false, opContinuation, []byte("men")),
+		clientFrame(true, opPong, []byte("unsolicited")),
+		clientFrame(true, opContinuation, []byte("ted")),
+	)
+	h, payload = c.read(t)
+	assert.Equal(t, opPong, h.opcode)
+	assert.Equal(t, "are you there", string(payload))
+	h, payload = c.read(t)
+	assert.Equal(t, opText, h.opcode)
+	assert.Equal(t, "fragmented", string(payload))
+
+	// Test: Messages are written in fragments when configured
+	s, _ = startEcho(t, Options{FragmentSize: 4})
+	c = dial(t, s, handshakeRequest(nil), clientFrame(true, opText, []byte("0123456789")))
+	var opcodes []byte
+	var joined []byte
+	for {
+		h, payload = c.read(t)
+		opcodes = append(opcodes, h.opcode)
+		joined = append(joined, payload...)
+		if h.fin {
+			break
+		}
+	}
+	assert.Equal(t, []byte{opText, opContinuation, opContinuation}, opcodes)
+	assert.Equal(t, "0123456789", string(joined))
+}
+
+func TestProtocolErrors(t *testing.T) {
+	s, ended := startEcho(t, Options{MaxMessageSize: 16})
+	cases := []struct {
+		name  string
+		frame []byte
+		code  int
+		err   error
+	}{
+		{"unmasked", appendFrame(nil, true, opText, []byte("hi"), nil), CloseProtocolError, ErrProtocol},
+		{"reserved bits", func() []byte { f := clientFrame(true, opText, []byte("hi")); f[0] |= 0x40; return f }(), CloseProtocolError, ErrProtocol},
+		{"unknown opcode", clientFrame(true, 0x3, []byte("hi")), CloseProtocolError, ErrProtocol},
+		{"stray continuation", clientFrame(true, opContinuation, []byte("hi")), CloseProtocolError, ErrProtocol},
+		{"fragmented ping", clientFrame(false, opPing, nil), CloseProtocolError, ErrProtocol},
+		{"long ping", clientFrame(true, opPing, make([]byte, 126)), CloseProtocolError, ErrProtocol},
+		{"interleaved message", append(clientFrame(false, opText, []byte("a")), clientFrame(true, opText, []byte("b"))...), CloseProtocolError, ErrProtocol},
+		{"invalid UTF-8", clientFrame(true, opText, []byte{0xc3, 0x28}), CloseInvalidPayload, ErrInvalidUTF8},
+		{"too big", clientFrame(true, opBinary, make([]byte, 17)), CloseMessageTooBig, ErrMessageTooBig},
+		{"too big in fragments", append(clientFrame(false, opBinary, make([]byte, 10)), clientFrame(true, opContinuation, make([]byte, 10))...), CloseMessageTooBig, ErrMessageTooBig},
+		{"invalid close code", clientFrame(true, opClose, []byte{0x03, 0xed}), CloseProtocolError, ErrProtocol},
+	}
+
+	// Test: Violations close the connection with the matching code
+	for _, tc := range cases {
+		c := dial(t, s, handshakeRequest(nil), tc.frame)
+		assert.Equal(t, tc.code, c.readClose(t), tc.name)
+		_, err := c.reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF, tc.name)
+		assert.ErrorIs(t, <-ended, tc.err, tc.name)
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	s, ended := startEcho(t, Options{})
+
+	// Test: A client close is answered with the same code and reported
+	c := dial(t, s, handshakeRequest(nil), nil)
+	c.send(t, clientFrame(true, opClose, append([]byte{0x03, 0xe8}, "done"...)))
+	assert.Equal(t, CloseNormal, c.readClose(t))
+	var closeErr *CloseError
+	require.True(t, errors.As(<-ended, &closeErr))
+	assert.Equal(t, &CloseError{Code: CloseNormal, Reason: "done"}, closeErr)
+
+	// Test: An empty close frame is answered with an empty one
+	c = dial(t, s, handshakeRequest(nil), nil)
+	c.send(t, clientFrame(true, opClose, nil))
+	h, payload := c.read(t)
+	assert.Equal(t, opClose, h.opcode)
+	assert.Empty(t, payload)
+	require.True(t, errors.As(<-ended, &closeErr))
+	assert.Equal(t, CloseNoStatus, closeErr.Code)
+
+	// Test: The server closes once the client answers its close frame
+	c = dial(t, s, handshakeRequest(nil), clientFrame(true, opText, []byte("close please")))
+	h, payload = c.read(t)
+	assert.Equal(t, opClose, h.opcode)
+	assert.Equal(t, append([]byte{0x03, 0xe9}, "bye"...), payload)
+	c.send(t, clientFrame(true, opClose, payload[:2]))
+	assert.NoError(t, <-ended)
+	_, err := c.reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}